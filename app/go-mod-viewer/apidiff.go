@@ -0,0 +1,411 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/doc"
+	"go/token"
+	"go/types"
+	"html"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// An apiObj is one exported element of a package's API:
+// a top-level declaration, a method, a struct field or an interface method.
+type apiObj struct {
+	kind string // "func", "method", "const", "var", "type", "field", "interface method"
+	text string // normalized form, compared across versions
+	pos  token.Pos
+}
+
+// packageAPI returns the exported API of p, keyed by name:
+// "F" for top-level declarations and "T.M" for methods and fields.
+//
+// The comparison is syntactic, since the viewer cannot type-check
+// against a package's dependencies: types are compared as written,
+// ignoring parameter names, so a change from one spelling of a type
+// to an equivalent one is reported as a change.
+// Unexported interface methods are not listed, but an interface
+// that has any is marked, since other packages cannot implement it.
+func packageAPI(p *goPackage) map[string]*apiObj {
+	api := make(map[string]*apiObj)
+	add := func(key, kind, text string, pos token.Pos) {
+		api[key] = &apiObj{kind, text, pos}
+	}
+	values := func(list []*doc.Value) {
+		for _, v := range list {
+			kind := v.Decl.Tok.String()
+			// Track implicit repetition of types and values in const blocks.
+			var typ ast.Expr
+			var vals []ast.Expr
+			for n, spec := range v.Decl.Specs {
+				vs := spec.(*ast.ValueSpec)
+				if vs.Type != nil || len(vs.Values) > 0 {
+					typ, vals = vs.Type, vs.Values
+				}
+				for i, id := range vs.Names {
+					if !id.IsExported() {
+						continue
+					}
+					text := ""
+					if typ != nil {
+						text = types.ExprString(typ)
+					}
+					if kind == "const" && i < len(vals) {
+						val := types.ExprString(vals[i])
+						if vs.Values == nil && strings.Contains(val, "iota") {
+							val += fmt.Sprintf(" (iota %d)", n)
+						}
+						text += " = " + val
+					}
+					add(id.Name, kind, strings.TrimSpace(text), id.Pos())
+				}
+			}
+		}
+	}
+	funcs := func(list []*doc.Func) {
+		for _, f := range list {
+			if f.Recv == "" {
+				add(f.Name, "func", signature(f.Decl.Type), f.Decl.Name.Pos())
+				continue
+			}
+			recv, _, _ := strings.Cut(strings.TrimPrefix(f.Recv, "*"), "[")
+			text := signature(f.Decl.Type)
+			if strings.HasPrefix(f.Recv, "*") {
+				text = "(pointer receiver) " + text
+			}
+			add(recv+"."+f.Name, "method", text, f.Decl.Name.Pos())
+		}
+	}
+
+	values(p.doc.Consts)
+	values(p.doc.Vars)
+	funcs(p.doc.Funcs)
+	for _, t := range p.doc.Types {
+		for _, spec := range t.Decl.Specs {
+			ts, ok := spec.(*ast.TypeSpec)
+			if !ok || ts.Name.Name != t.Name {
+				continue
+			}
+			tparams := ""
+			if ts.TypeParams != nil {
+				tparams = types.ExprString(&ast.IndexListExpr{X: ast.NewIdent(""), Indices: fieldTypes(ts.TypeParams)})
+			}
+			switch typ := ts.Type.(type) {
+			case *ast.StructType:
+				add(t.Name, "type", tparams+"struct", ts.Name.Pos())
+				for _, f := range typ.Fields.List {
+					for _, name := range fieldNames(f) {
+						if token.IsExported(name.Name) {
+							add(t.Name+"."+name.Name, "field", types.ExprString(f.Type), name.Pos())
+						}
+					}
+				}
+			case *ast.InterfaceType:
+				text := tparams + "interface"
+				if typ.Incomplete {
+					// go/doc removed unexported methods or embedded interfaces,
+					// so other packages may be unable to implement the interface.
+					text += unexportedMethods
+				}
+				add(t.Name, "type", text, ts.Name.Pos())
+				for _, m := range typ.Methods.List {
+					if ft, ok := m.Type.(*ast.FuncType); ok {
+						add(t.Name+"."+m.Names[0].Name, "interface method", signature(ft), m.Names[0].Pos())
+					} else {
+						add(t.Name+"."+types.ExprString(m.Type), "interface method", "embedded", m.Type.Pos())
+					}
+				}
+			default:
+				text := tparams + types.ExprString(ts.Type)
+				if ts.Assign.IsValid() {
+					text = "= " + text
+				}
+				add(t.Name, "type", text, ts.Name.Pos())
+			}
+		}
+		values(t.Consts)
+		values(t.Vars)
+		funcs(t.Funcs)
+		funcs(t.Methods)
+	}
+	return api
+}
+
+// unexportedMethods is appended to the text of an interface type
+// that has unexported methods or embeds unexported interfaces.
+const unexportedMethods = " with unexported methods"
+
+// signature returns the function type ft without parameter names.
+func signature(ft *ast.FuncType) string {
+	strip := func(list *ast.FieldList) *ast.FieldList {
+		if list == nil {
+			return nil
+		}
+		out := new(ast.FieldList)
+		for _, t := range fieldTypes(list) {
+			out.List = append(out.List, &ast.Field{Type: t})
+		}
+		return out
+	}
+	s := types.ExprString(&ast.FuncType{Params: strip(ft.Params), Results: strip(ft.Results)})
+	if ft.TypeParams != nil {
+		s = types.ExprString(&ast.IndexListExpr{X: ast.NewIdent(""), Indices: fieldTypes(ft.TypeParams)}) + s
+	}
+	return s
+}
+
+// fieldTypes returns the types in list, one per name.
+func fieldTypes(list *ast.FieldList) []ast.Expr {
+	var types []ast.Expr
+	for _, f := range list.List {
+		n := len(f.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			types = append(types, f.Type)
+		}
+	}
+	return types
+}
+
+// fieldNames returns the names declared by the struct field f,
+// which for an embedded field is the name of its type.
+func fieldNames(f *ast.Field) []*ast.Ident {
+	if len(f.Names) > 0 {
+		return f.Names
+	}
+	t := f.Type
+	for {
+		switch x := t.(type) {
+		case *ast.StarExpr:
+			t = x.X
+			continue
+		case *ast.SelectorExpr:
+			return []*ast.Ident{x.Sel}
+		case *ast.IndexExpr:
+			t = x.X
+			continue
+		case *ast.IndexListExpr:
+			t = x.X
+			continue
+		case *ast.Ident:
+			return []*ast.Ident{x}
+		}
+		return nil
+	}
+}
+
+// An apiChange is a single difference between two versions of a package API.
+type apiChange struct {
+	name       string
+	msg        string
+	old, new   string // source links, or ""
+	compatible bool
+}
+
+// diffAPI returns the changes from the API old to the API new,
+// following the rules of golang.org/x/exp/apidiff where they can be
+// applied without type information.
+func diffAPI(pold, pnew *goPackage) []apiChange {
+	old, new := packageAPI(pold), packageAPI(pnew)
+	var changes []apiChange
+	for name, o := range old {
+		n := new[name]
+		switch {
+		case n == nil:
+			changes = append(changes, apiChange{name: name, msg: o.kind + " removed", old: pold.sourceLink(o.pos)})
+		case n.kind != o.kind:
+			changes = append(changes, apiChange{name: name, msg: fmt.Sprintf("changed from %s to %s", o.kind, n.kind),
+				old: pold.sourceLink(o.pos), new: pnew.sourceLink(n.pos)})
+		case n.text == o.text+unexportedMethods:
+			// Other packages can no longer implement the interface.
+			changes = append(changes, apiChange{name: name, msg: "unexported methods added",
+				old: pold.sourceLink(o.pos), new: pnew.sourceLink(n.pos)})
+		case o.text == n.text+unexportedMethods:
+			changes = append(changes, apiChange{name: name, msg: "unexported methods removed",
+				old: pold.sourceLink(o.pos), new: pnew.sourceLink(n.pos), compatible: true})
+		case n.text != o.text:
+			if o.kind == "var" && (o.text == "" || n.text == "") {
+				// Types inferred from initializers cannot be compared.
+				continue
+			}
+			changes = append(changes, apiChange{name: name, msg: fmt.Sprintf("changed from %s to %s", o.text, n.text),
+				old: pold.sourceLink(o.pos), new: pnew.sourceLink(n.pos)})
+		}
+	}
+	for name, n := range new {
+		if old[name] != nil {
+			continue
+		}
+		c := apiChange{name: name, msg: n.kind + " added", new: pnew.sourceLink(n.pos), compatible: true}
+		if n.kind == "interface method" {
+			// Existing implementations of the interface no longer satisfy it,
+			// unless the interface is new too.
+			typ, _, _ := strings.Cut(name, ".")
+			c.compatible = old[typ] == nil
+		}
+		changes = append(changes, c)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].name < changes[j].name })
+	return changes
+}
+
+// maxAPIPackages is the maximum number of packages serveAPIDiff compares.
+const maxAPIPackages = 200
+
+// serveAPIDiff serves the changes to the exported API of each package
+// in the directory tree dir ("" for the whole module) between mod@v1 and mod@v2,
+// whose zip indexes are ix[0] and ix[1].
+// Commands and internal and testdata directories are skipped.
+func serveAPIDiff(ctx context.Context, ix [2]*zipIndex, mod, v1, v2, dir string) *response {
+	vers := []string{v1, v2}
+
+	// Find directories with Go files in either version.
+	seen := make(map[string]bool)
+	var dirs []string
+	for i, v := range vers {
+		prefix := mod + "@" + v + "/"
+		for _, zf := range ix[i].Files {
+			name := strings.TrimPrefix(zf.Name, prefix)
+			if !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+				continue
+			}
+			d := path.Dir(name)
+			if d == "." {
+				d = ""
+			}
+			if dir != "" && d != dir && !strings.HasPrefix(d, dir+"/") {
+				continue
+			}
+			elems := "/" + d + "/"
+			if strings.Contains(elems, "/internal/") || strings.Contains(elems, "/testdata/") || strings.Contains(elems, "/_") {
+				continue
+			}
+			if !seen[d] {
+				seen[d] = true
+				dirs = append(dirs, d)
+			}
+		}
+	}
+	sort.Strings(dirs)
+	truncated := len(dirs) > maxAPIPackages
+	if truncated {
+		dirs = dirs[:maxAPIPackages]
+	}
+
+	// Load the packages in parallel.
+	pkgs := make([][2]*goPackage, len(dirs))
+	errs := make([][2]error, len(dirs))
+	var wg sync.WaitGroup
+	sema := make(chan bool, 8)
+	for i, d := range dirs {
+		for j := range vers {
+			wg.Add(1)
+			sema <- true
+			go func(i, j int, d string) {
+				defer func() {
+					<-sema
+					wg.Done()
+				}()
+				p, err := loadPackage(ctx, ix[j], mod, vers[j], d)
+				errs[i][j] = err
+				if p != nil && p.doc.Name != "main" {
+					pkgs[i][j] = p
+				}
+			}(i, j, d)
+		}
+	}
+	wg.Wait()
+
+	file := "@api"
+	if dir != "" {
+		file = dir + "/@api"
+	}
+	var buf bytes.Buffer
+	e := html.EscapeString
+	printHeader(&buf, mod, v1+".."+v2, file, nil)
+	link := func(href, text string) string {
+		if href == "" {
+			return e(text)
+		}
+		return fmt.Sprintf("<a href=\"%s\">%s</a>", e(href), e(text))
+	}
+	none := true
+	volatile := false
+	for i, d := range dirs {
+		importPath := mod
+		if d != "" {
+			importPath += "/" + d
+		}
+		p := pkgs[i]
+		err := errs[i][0]
+		if err == nil {
+			err = errs[i][1]
+		}
+		if err != nil {
+			fmt.Fprintf(&buf, "<b>package %s</b>\n%s\n\n", e(importPath), e(err.Error()))
+			none = false
+			volatile = true
+			continue
+		}
+		var changes []apiChange
+		switch {
+		case p[0] == nil && p[1] == nil:
+			continue
+		case p[0] == nil:
+			changes = []apiChange{{name: "package", msg: "added", compatible: true}}
+		case p[1] == nil:
+			changes = []apiChange{{name: "package", msg: "removed"}}
+		default:
+			changes = diffAPI(p[0], p[1])
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		none = false
+		fmt.Fprintf(&buf, "<b>package %s</b>\n", e(importPath))
+		for _, compatible := range []bool{false, true} {
+			title := "<span class=\"del\">Incompatible changes:</span>\n"
+			if compatible {
+				title = "<span class=\"add\">Compatible changes:</span>\n"
+			}
+			for _, c := range changes {
+				if c.compatible != compatible {
+					continue
+				}
+				buf.WriteString(title)
+				title = ""
+				href := c.new
+				if href == "" {
+					href = c.old
+				}
+				fmt.Fprintf(&buf, "- %s: %s", link(href, c.name), e(c.msg))
+				if c.old != "" && c.new != "" {
+					fmt.Fprintf(&buf, " <small>(%s, %s)</small>", link(c.old, v1), link(c.new, v2))
+				}
+				buf.WriteString("\n")
+			}
+		}
+		buf.WriteString("\n")
+	}
+	if none {
+		buf.WriteString("No API changes.\n")
+	}
+	if truncated {
+		fmt.Fprintf(&buf, "Only the first %d packages were compared.\n", maxAPIPackages)
+	}
+	resp := htmlResponse(&buf)
+	resp.Volatile = volatile // errors may be transient
+	return resp
+}
@@ -0,0 +1,206 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/appengine/v2/memcache"
+)
+
+// A cache is a store for byte slices that expire after a given time.
+// Keys are short strings made of letters, digits and dots.
+type cache interface {
+	// Get returns the data stored for key,
+	// or errCacheMiss if there is none or it has expired.
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// Set stores data for key, to expire after ttl.
+	Set(ctx context.Context, key string, data []byte, ttl time.Duration) error
+}
+
+var errCacheMiss = errors.New("cache miss")
+
+// Cached data has three kinds, stored under different key prefixes
+// and kept for different times.
+// Volatile pages are rendered pages kept only briefly.
+const (
+	viewTTL     = 15 * time.Minute // rendered pages ("view.")
+	volatileTTL = 1 * time.Minute  // volatile rendered pages ("view.")
+	indexTTL    = 24 * time.Hour   // zip indexes ("zipindex.")
+	dataTTL     = 1 * time.Hour    // raw compressed file data from zips ("zipdata.")
+)
+
+// viewerCache is the cache used for all cached data.
+// It is set in main by newCache.
+var viewerCache cache = newMemoryCache(defaultMemoryCache)
+
+const defaultMemoryCache = 256 << 20
+
+// newCache returns the cache described by spec, which is one of:
+//
+//	memory[:maxbytes]  an in-memory LRU cache (default 256 MB)
+//	disk:dir           files in the directory dir
+//	memcache           App Engine memcache
+//
+// An empty spec means memcache when running on App Engine
+// and memory otherwise.
+func newCache(spec string) (cache, error) {
+	if spec == "" {
+		spec = "memory"
+		if deployID != "" {
+			spec = "memcache"
+		}
+	}
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "memory":
+		max := int64(defaultMemoryCache)
+		if arg != "" {
+			n, err := strconv.ParseInt(arg, 0, 64)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid cache spec %q", spec)
+			}
+			max = n
+		}
+		return newMemoryCache(max), nil
+	case "disk":
+		if arg == "" {
+			return nil, fmt.Errorf("invalid cache spec %q: missing directory", spec)
+		}
+		if err := os.MkdirAll(arg, 0777); err != nil {
+			return nil, err
+		}
+		return &diskCache{dir: arg}, nil
+	case "memcache":
+		return memcacheCache{}, nil
+	}
+	return nil, fmt.Errorf("unknown cache kind %q", kind)
+}
+
+// A memoryCache is an in-memory LRU cache holding at most max bytes of data.
+type memoryCache struct {
+	mu    sync.Mutex
+	max   int64
+	size  int64
+	lru   *list.List // of *memoryEntry, most recently used first
+	items map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key     string
+	data    []byte
+	expires time.Time
+}
+
+func newMemoryCache(max int64) *memoryCache {
+	return &memoryCache{max: max, lru: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (c *memoryCache) Get(ctx context.Context, key string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem := c.items[key]
+	if elem == nil {
+		return nil, errCacheMiss
+	}
+	e := elem.Value.(*memoryEntry)
+	if time.Now().After(e.expires) {
+		c.remove(elem)
+		return nil, errCacheMiss
+	}
+	c.lru.MoveToFront(elem)
+	return e.data, nil
+}
+
+func (c *memoryCache) Set(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	if int64(len(data)) > c.max/8 {
+		// Too big to be worth evicting everything else for.
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem := c.items[key]; elem != nil {
+		c.remove(elem)
+	}
+	c.items[key] = c.lru.PushFront(&memoryEntry{key, data, time.Now().Add(ttl)})
+	c.size += int64(len(data))
+	for c.size > c.max {
+		c.remove(c.lru.Back())
+	}
+	return nil
+}
+
+func (c *memoryCache) remove(elem *list.Element) {
+	e := elem.Value.(*memoryEntry)
+	c.lru.Remove(elem)
+	delete(c.items, e.key)
+	c.size -= int64(len(e.data))
+}
+
+// A diskCache stores each entry in a file in dir.
+// The file holds the expiration time, as big-endian Unix nanoseconds,
+// followed by the data.
+// Expired files are ignored but not removed.
+type diskCache struct {
+	dir string
+}
+
+func (c *diskCache) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(c.dir, key))
+	if err != nil || len(data) < 8 {
+		return nil, errCacheMiss
+	}
+	if time.Now().UnixNano() > int64(binary.BigEndian.Uint64(data)) {
+		return nil, errCacheMiss
+	}
+	return data[8:], nil
+}
+
+func (c *diskCache) Set(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	f, err := os.CreateTemp(c.dir, key+".tmp")
+	if err != nil {
+		return err
+	}
+	var hdr [8]byte
+	binary.BigEndian.PutUint64(hdr[:], uint64(time.Now().Add(ttl).UnixNano()))
+	_, err1 := f.Write(hdr[:])
+	_, err2 := f.Write(data)
+	err3 := f.Close()
+	if err := errors.Join(err1, err2, err3); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(c.dir, key))
+}
+
+// memcacheCache is a cache backed by App Engine memcache.
+// Its context must come from appengine.NewContext.
+type memcacheCache struct{}
+
+func (memcacheCache) Get(ctx context.Context, key string) ([]byte, error) {
+	item, err := memcache.Get(ctx, key)
+	if err == memcache.ErrCacheMiss {
+		return nil, errCacheMiss
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item.Value, nil
+}
+
+func (memcacheCache) Set(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	return memcache.Set(ctx, &memcache.Item{Key: key, Value: data, Expiration: ttl})
+}
@@ -0,0 +1,339 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/mod/sumdb"
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+// A sumCheck is the result of checking a module zip against the checksum database.
+type sumCheck struct {
+	Hash     string // h1: hash of the zip, or "" if not computed
+	Verified bool   // Hash matches the checksum database
+	Mismatch string // hash from the checksum database, if different from Hash
+	Note     string // reason Hash is not verified, if not Verified
+}
+
+// final reports whether c is a final verdict,
+// which no later check of the same zip can change.
+func (c *sumCheck) final() bool {
+	return c.Verified || c.Mismatch != ""
+}
+
+// notYetChecked is the sumCheck Note for a zip whose check has not finished.
+const notYetChecked = "not yet checked"
+
+// sumRetry is how long checkSum keeps a verdict that is not final,
+// such as a network error, before checking the zip again.
+const sumRetry = 5 * time.Minute
+
+// checkSum returns the result of checking ix, the zip for mod@vers,
+// against the checksum database. Hashing the zip requires downloading
+// all of it, so the check runs in the background, once per zip,
+// starting on first use, and its final verdict is kept in ix and in viewerCache.
+// Until the check finishes, checkSum reports the zip as not yet checked,
+// unless wait is set, in which case it waits for the check as long as ctx allows.
+// Pages use wait = false; the @checksum page waits.
+func (ix *zipIndex) checkSum(ctx context.Context, mod, vers string, wait bool) *sumCheck {
+	ix.sumMu.Lock()
+	if ix.check == nil && ix.checking == nil {
+		if data, err := viewerCache.Get(ctx, ix.cacheKey("zipsum")); err == nil {
+			c := new(sumCheck)
+			if err := gob.NewDecoder(bytes.NewReader(data)).Decode(c); err != nil {
+				log.Printf("%s: bad cached checksum verdict: %v", ix.URL, err)
+			} else {
+				ix.check, ix.checked = c, time.Now()
+			}
+		}
+	}
+	c := ix.check
+	if c != nil && (c.final() || time.Since(ix.checked) < sumRetry) {
+		ix.sumMu.Unlock()
+		return c
+	}
+	done := ix.checking
+	if done == nil {
+		done = make(chan struct{})
+		ix.checking = done
+		go ix.runCheck(detachedContext{ctx}, mod, vers, done)
+	}
+	ix.sumMu.Unlock()
+
+	if wait {
+		select {
+		case <-done:
+			ix.sumMu.Lock()
+			c = ix.check
+			ix.sumMu.Unlock()
+		case <-ctx.Done():
+		}
+	}
+	if c == nil {
+		c = &sumCheck{Note: notYetChecked}
+	}
+	return c
+}
+
+// runCheck checks ix, the zip for mod@vers, against the checksum database,
+// records the result in ix and, if it is final, in viewerCache,
+// and then closes done.
+func (ix *zipIndex) runCheck(ctx context.Context, mod, vers string, done chan struct{}) {
+	c := ix.lookupSum(ctx, mod, vers)
+	if c.final() {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(c); err != nil {
+			log.Printf("%s: encoding checksum verdict: %v", ix.URL, err)
+		} else if err := viewerCache.Set(ctx, ix.cacheKey("zipsum"), buf.Bytes(), indexTTL); err != nil {
+			log.Print(err)
+		}
+	}
+	ix.sumMu.Lock()
+	ix.check, ix.checked, ix.checking = c, time.Now(), nil
+	ix.sumMu.Unlock()
+	close(done)
+}
+
+// lookupSum hashes ix, the zip for mod@vers,
+// and looks up its hash in the checksum database.
+func (ix *zipIndex) lookupSum(ctx context.Context, mod, vers string) *sumCheck {
+	c := new(sumCheck)
+	var err error
+	c.Hash, err = ix.zipHash(ctx)
+	if err != nil {
+		c.Note = "not checked: " + err.Error()
+		return c
+	}
+	client, err := sumdbClient()
+	if err != nil {
+		c.Note = err.Error()
+		return c
+	}
+	lines, err := client.Lookup(mod, vers)
+	if err != nil {
+		if errors.Is(err, sumdb.ErrGONOSUMDB) {
+			c.Note = "not checked: module is private"
+		} else {
+			c.Note = "not checked: " + err.Error()
+		}
+		return c
+	}
+	prefix := mod + " " + vers + " "
+	for _, line := range lines {
+		if strings.HasPrefix(line, prefix) {
+			if want := strings.TrimPrefix(line, prefix); want != c.Hash {
+				c.Mismatch = want
+				return c
+			}
+			c.Verified = true
+			return c
+		}
+	}
+	c.Note = "not checked: no checksum in database"
+	return c
+}
+
+// zipHash returns the h1: hash of the zip, as recorded in go.sum files.
+// Computing it requires downloading the whole zip,
+// so the hash is kept in ix and in viewerCache.
+func (ix *zipIndex) zipHash(ctx context.Context) (string, error) {
+	ix.hashMu.Lock()
+	defer ix.hashMu.Unlock()
+	if h := ix.cachedHashLocked(ctx); h != "" {
+		return h, nil
+	}
+	zr, err := ix.openZip()
+	if err != nil {
+		return "", err
+	}
+	files := make([]string, 0, len(zr.File))
+	byName := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files = append(files, f.Name)
+		byName[f.Name] = f
+	}
+	h, err := dirhash.Hash1(files, func(name string) (io.ReadCloser, error) {
+		return byName[name].Open()
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := viewerCache.Set(ctx, ix.cacheKey("ziphash"), []byte(h), indexTTL); err != nil {
+		log.Print(err)
+	}
+	ix.hash = h
+	return h, nil
+}
+
+// cachedHash returns the h1: hash of the zip if zipHash has computed it,
+// or else "". It never downloads the zip.
+func (ix *zipIndex) cachedHash(ctx context.Context) string {
+	ix.hashMu.Lock()
+	defer ix.hashMu.Unlock()
+	return ix.cachedHashLocked(ctx)
+}
+
+// cachedHashLocked is cachedHash with ix.hashMu held.
+func (ix *zipIndex) cachedHashLocked(ctx context.Context) string {
+	if ix.hash == "" {
+		if data, err := viewerCache.Get(ctx, ix.cacheKey("ziphash")); err == nil {
+			ix.hash = string(data)
+		}
+	}
+	return ix.hash
+}
+
+// cacheKey returns the viewerCache key for data of the given kind
+// about the zip as a whole, such as its hash.
+func (ix *zipIndex) cacheKey(kind string) string {
+	sum := sha256.Sum256([]byte(ix.URL))
+	return fmt.Sprintf("%s.%x", kind, sum[:])
+}
+
+// A detachedContext has the values of its parent context but is never canceled,
+// for work started by a request that outlives it.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+// serveChecksum serves mod@vers/@checksum, which shows the result
+// of checking the zip ix against the checksum database,
+// waiting for the check if it has not finished.
+func serveChecksum(ctx context.Context, ix *zipIndex, mod, vers string) *response {
+	c := ix.checkSum(ctx, mod, vers, true)
+	var buf bytes.Buffer
+	printHeader(&buf, mod, vers, "@checksum", c)
+	switch {
+	case c.Mismatch != "":
+		buf.WriteString("The module zip does not match the checksum database.\n")
+	case c.Verified:
+		buf.WriteString("The module zip matches the checksum database.\n")
+	default:
+		fmt.Fprintf(&buf, "The module zip was %s.\n", html.EscapeString(c.Note))
+	}
+	return pageResponse(&buf, c)
+}
+
+// The checksum database is configured by $GOSUMDB, as for the go command:
+// "sum.golang.org" (the default), "off", or "name+hash+key [url]".
+// The URL may be a file:// URL, for testing against a local database.
+// Modules matching $GONOSUMDB or $GOPRIVATE are not checked.
+var sumdbState struct {
+	once   sync.Once
+	client *sumdb.Client
+	err    error
+}
+
+// knownSumDB is the verifier key for sum.golang.org.
+const knownSumDB = "sum.golang.org+033de0ae+Ac4zctda0e5eza+HJyk9SxEdh+s3Ux18htTTAD8OuAn8"
+
+func sumdbClient() (*sumdb.Client, error) {
+	sumdbState.once.Do(func() {
+		sumdbState.client, sumdbState.err = newSumDBClient(os.Getenv("GOSUMDB"))
+		if sumdbState.client != nil {
+			nosumdb := os.Getenv("GONOSUMDB")
+			if nosumdb == "" {
+				nosumdb = os.Getenv("GOPRIVATE")
+			}
+			sumdbState.client.SetGONOSUMDB(nosumdb)
+		}
+	})
+	return sumdbState.client, sumdbState.err
+}
+
+func newSumDBClient(spec string) (*sumdb.Client, error) {
+	if spec == "" {
+		spec = "sum.golang.org"
+	}
+	if spec == "off" {
+		return nil, errors.New("not checked: GOSUMDB=off")
+	}
+	key, u, _ := strings.Cut(spec, " ")
+	if key == "sum.golang.org" || key == "sum.golang.google.cn" {
+		if u == "" {
+			u = "https://" + key
+		}
+		key = knownSumDB
+	}
+	name, _, _ := strings.Cut(key, "+")
+	if u == "" {
+		u = "https://" + name
+	}
+	return sumdb.NewClient(&sumdbOps{
+		key:    key,
+		url:    strings.TrimSuffix(strings.TrimSpace(u), "/"),
+		config: make(map[string][]byte),
+		cache:  newMemoryCache(32 << 20),
+	}), nil
+}
+
+// sumdbOps implements sumdb.ClientOps, keeping the latest signed tree
+// and a cache of tiles and lookups in memory.
+type sumdbOps struct {
+	key string
+	url string
+
+	mu     sync.Mutex
+	config map[string][]byte
+	cache  *memoryCache
+}
+
+func (o *sumdbOps) ReadRemote(path string) ([]byte, error) {
+	return fetchURL(o.url + path)
+}
+
+func (o *sumdbOps) ReadConfig(file string) ([]byte, error) {
+	if file == "key" {
+		return []byte(o.key), nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.config[file], nil
+}
+
+func (o *sumdbOps) WriteConfig(file string, old, new []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !bytes.Equal(o.config[file], old) {
+		return sumdb.ErrWriteConflict
+	}
+	o.config[file] = new
+	return nil
+}
+
+func (o *sumdbOps) ReadCache(file string) ([]byte, error) {
+	return o.cache.Get(context.Background(), file)
+}
+
+func (o *sumdbOps) WriteCache(file string, data []byte) {
+	o.cache.Set(context.Background(), file, data, indexTTL)
+}
+
+func (o *sumdbOps) Log(msg string) {
+	log.Print(msg)
+}
+
+func (o *sumdbOps) SecurityError(msg string) {
+	log.Printf("checksum database security error: %s", msg)
+}
@@ -0,0 +1,351 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// maxDepsModules is the maximum number of go.mod files loadDeps fetches.
+const maxDepsModules = 500
+
+// A depsGraph is the module requirement graph of a main module.
+type depsGraph struct {
+	main     module.Version
+	reqs     map[module.Version][]module.Version // requirements of each module in the graph
+	selected map[string]string                   // path -> version selected by MVS
+	replace  map[module.Version]module.Version   // replacements, by old module; version "" matches all
+	errs     map[module.Version]error            // errors loading go.mod files
+	excluded []module.Version                    // requirements ignored because of exclude directives
+	trunc    bool                                // graph truncated at maxDepsModules
+}
+
+// loadGoMod returns the parsed go.mod file for m from the proxy,
+// using viewerCache.
+func loadGoMod(ctx context.Context, m module.Version) (*modfile.File, error) {
+	epath, err := module.EscapePath(m.Path)
+	if err != nil {
+		return nil, err
+	}
+	evers, err := module.EscapeVersion(m.Version)
+	if err != nil {
+		return nil, err
+	}
+	name := epath + "/@v/" + evers + ".mod"
+	sum := sha256.Sum256([]byte(name))
+	key := fmt.Sprintf("gomod.%x", sum[:])
+	data, err := viewerCache.Get(ctx, key)
+	if err != nil {
+		data, err = fetchProxy(name)
+		if err != nil {
+			return nil, err
+		}
+		if err := viewerCache.Set(ctx, key, data, indexTTL); err != nil {
+			log.Print(err)
+		}
+	}
+	return modfile.ParseLax(name, data, nil)
+}
+
+// replacement returns the module whose go.mod supplies the requirements of m,
+// along with whether m is replaced at all.
+func (g *depsGraph) replacement(m module.Version) (module.Version, bool) {
+	if r, ok := g.replace[m]; ok {
+		return r, true
+	}
+	if r, ok := g.replace[module.Version{Path: m.Path}]; ok {
+		return r, true
+	}
+	return m, false
+}
+
+// loadDeps loads the requirement graph of main and computes the MVS build list.
+// As in the go command, only the main module's replace and exclude directives apply,
+// and, since Go 1.16, requirements on excluded versions are ignored.
+// The graph is the complete one used before module graph pruning (Go 1.17),
+// so it may select higher versions than a pruned graph would.
+func loadDeps(ctx context.Context, main module.Version) (*depsGraph, error) {
+	mf, err := loadGoMod(ctx, main)
+	if err != nil {
+		return nil, err
+	}
+	g := &depsGraph{
+		main:     main,
+		reqs:     make(map[module.Version][]module.Version),
+		selected: map[string]string{main.Path: main.Version},
+		replace:  make(map[module.Version]module.Version),
+		errs:     make(map[module.Version]error),
+	}
+	exclude := make(map[module.Version]bool)
+	for _, x := range mf.Exclude {
+		exclude[x.Mod] = true
+	}
+	for _, r := range mf.Replace {
+		g.replace[r.Old] = r.New
+	}
+	var mu sync.Mutex
+	reported := make(map[module.Version]bool)
+	requires := func(f *modfile.File) []module.Version {
+		var list []module.Version
+		for _, r := range f.Require {
+			if exclude[r.Mod] {
+				mu.Lock()
+				if !reported[r.Mod] {
+					reported[r.Mod] = true
+					g.excluded = append(g.excluded, r.Mod)
+				}
+				mu.Unlock()
+				continue
+			}
+			list = append(list, r.Mod)
+		}
+		return list
+	}
+	g.reqs[main] = requires(mf)
+
+	// Walk the graph breadth-first, fetching each level's go.mod files in parallel.
+	seen := map[module.Version]bool{main: true}
+	work := g.reqs[main]
+	for len(work) > 0 {
+		var next []module.Version
+		for _, m := range work {
+			if seen[m] {
+				continue
+			}
+			if len(seen) >= maxDepsModules {
+				g.trunc = true
+				break
+			}
+			seen[m] = true
+			next = append(next, m)
+		}
+		var wg sync.WaitGroup
+		sema := make(chan bool, 10)
+		for _, m := range next {
+			wg.Add(1)
+			sema <- true
+			go func(m module.Version) {
+				defer func() {
+					<-sema
+					wg.Done()
+				}()
+				r, _ := g.replacement(m)
+				var list []module.Version
+				var err error
+				if r.Version != "" {
+					var f *modfile.File
+					f, err = loadGoMod(ctx, r)
+					if err == nil {
+						list = requires(f)
+					}
+				}
+				// A replacement by a local directory has requirements we cannot see.
+				mu.Lock()
+				g.reqs[m] = list
+				if err != nil {
+					g.errs[m] = err
+				}
+				mu.Unlock()
+			}(m)
+		}
+		wg.Wait()
+		work = nil
+		for _, m := range next {
+			work = append(work, g.reqs[m]...)
+		}
+	}
+	for m := range g.reqs {
+		if m.Path != main.Path && semver.Compare(m.Version, g.selected[m.Path]) > 0 {
+			g.selected[m.Path] = m.Version
+		}
+	}
+	return g, nil
+}
+
+// buildList returns the selected module versions, main module first
+// and the rest sorted by path.
+func (g *depsGraph) buildList() []module.Version {
+	var list []module.Version
+	for path, vers := range g.selected {
+		if path != g.main.Path {
+			list = append(list, module.Version{Path: path, Version: vers})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	return append([]module.Version{g.main}, list...)
+}
+
+// nodes returns the modules in the graph, main module first
+// and the rest sorted by path and version.
+func (g *depsGraph) nodes() []module.Version {
+	var list []module.Version
+	for m := range g.reqs {
+		if m != g.main {
+			list = append(list, m)
+		}
+	}
+	module.Sort(list)
+	return append([]module.Version{g.main}, list...)
+}
+
+// serveDeps serves the module requirement graph of mod@vers, as HTML,
+// or as Graphviz DOT or JSON when the format parameter is "dot" or "json".
+func serveDeps(ctx context.Context, mod, vers string, query url.Values) *response {
+	g, err := loadDeps(ctx, module.Version{Path: mod, Version: vers})
+	if err != nil {
+		return proxyErrorResponse(err)
+	}
+	var resp *response
+	switch query.Get("format") {
+	case "dot":
+		resp = g.dot()
+	case "json":
+		resp = g.json()
+	case "":
+		resp = g.html(mod, vers)
+	default:
+		return errorResponse(http.StatusBadRequest, "Unknown format %q.", query.Get("format"))
+	}
+	// Errors loading go.mod files may be transient.
+	resp.Volatile = len(g.errs) > 0
+	return resp
+}
+
+// html returns the graph and build list of mod@vers as an HTML page.
+func (g *depsGraph) html(mod, vers string) *response {
+
+	var buf bytes.Buffer
+	e := html.EscapeString
+	printHeader(&buf, mod, vers, "@deps", nil)
+	fmt.Fprintf(&buf, "<small>(<a href=\"/%s@%s/@deps?format=dot\">dot</a>, <a href=\"/%s@%s/@deps?format=json\">json</a>)</small>\n\n",
+		e(mod), e(vers), e(mod), e(vers))
+	link := func(m module.Version) string {
+		return fmt.Sprintf("<a href=\"/%s@%s\">%s %s</a>", e(m.Path), e(m.Version), e(m.Path), e(m.Version))
+	}
+	replaced := func(m module.Version) string {
+		r, ok := g.replacement(m)
+		if !ok {
+			return ""
+		}
+		if r.Version == "" {
+			return " => " + e(r.Path)
+		}
+		return " => " + link(r)
+	}
+
+	list := g.buildList()
+	fmt.Fprintf(&buf, "<b>Selected versions</b> (%d modules)\n\n", len(list))
+	for _, m := range list {
+		buf.WriteString(link(m) + replaced(m) + "\n")
+	}
+	if len(g.excluded) > 0 {
+		buf.WriteString("\n<b>Excluded requirements</b>\n\n")
+		for _, m := range g.excluded {
+			buf.WriteString(e(m.Path+" "+m.Version) + "\n")
+		}
+	}
+
+	buf.WriteString("\n<b>Requirement graph</b>\n")
+	for _, m := range g.nodes() {
+		fmt.Fprintf(&buf, "\n%s", link(m))
+		if m != g.main && g.selected[m.Path] != m.Version {
+			fmt.Fprintf(&buf, " <small>(not selected)</small>")
+		}
+		buf.WriteString(replaced(m) + "\n")
+		if err := g.errs[m]; err != nil {
+			fmt.Fprintf(&buf, "\t<span class=\"del\">%s</span>\n", e(err.Error()))
+		}
+		for _, r := range g.reqs[m] {
+			fmt.Fprintf(&buf, "\t%s", link(r))
+			if sel := g.selected[r.Path]; sel != r.Version {
+				fmt.Fprintf(&buf, " => %s", e(sel))
+			}
+			buf.WriteString("\n")
+		}
+	}
+	if g.trunc {
+		fmt.Fprintf(&buf, "\nGraph truncated at %d modules.\n", maxDepsModules)
+	}
+	return htmlResponse(&buf)
+}
+
+// dot returns the graph in Graphviz DOT format,
+// with the selected versions drawn in bold.
+func (g *depsGraph) dot() *response {
+	var buf bytes.Buffer
+	id := func(m module.Version) string {
+		return fmt.Sprintf("%q", m.Path+"@"+m.Version)
+	}
+	buf.WriteString("digraph deps {\n")
+	for _, m := range g.nodes() {
+		if g.selected[m.Path] == m.Version {
+			fmt.Fprintf(&buf, "\t%s [style=bold];\n", id(m))
+		}
+		for _, r := range g.reqs[m] {
+			fmt.Fprintf(&buf, "\t%s -> %s;\n", id(m), id(r))
+		}
+	}
+	buf.WriteString("}\n")
+	return &response{Status: http.StatusOK, ContentType: "text/plain; charset=utf-8", Body: buf.Bytes()}
+}
+
+// depsJSON is the JSON form of a depsGraph.
+type depsJSON struct {
+	Main     module.Version
+	Selected []depsModule
+	Graph    []depsModule
+	Excluded []module.Version `json:",omitempty"`
+	Trunc    bool             `json:",omitempty"`
+}
+
+type depsModule struct {
+	Path    string
+	Version string
+	Replace *module.Version  `json:",omitempty"`
+	Require []module.Version `json:",omitempty"`
+	Error   string           `json:",omitempty"`
+}
+
+// json returns the graph and build list as JSON.
+func (g *depsGraph) json() *response {
+	mod := func(m module.Version) depsModule {
+		dm := depsModule{Path: m.Path, Version: m.Version}
+		if r, ok := g.replacement(m); ok {
+			dm.Replace = &r
+		}
+		return dm
+	}
+	out := depsJSON{Main: g.main, Excluded: g.excluded, Trunc: g.trunc}
+	for _, m := range g.buildList() {
+		out.Selected = append(out.Selected, mod(m))
+	}
+	for _, m := range g.nodes() {
+		dm := mod(m)
+		dm.Require = g.reqs[m]
+		if err := g.errs[m]; err != nil {
+			dm.Error = err.Error()
+		}
+		out.Graph = append(out.Graph, dm)
+	}
+	data, err := json.MarshalIndent(out, "", "\t")
+	if err != nil {
+		return errorResponse(http.StatusInternalServerError, "%v", err)
+	}
+	return &response{Status: http.StatusOK, ContentType: "application/json", Body: append(data, '\n')}
+}
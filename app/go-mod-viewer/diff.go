@@ -0,0 +1,236 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strings"
+)
+
+// A diffOp is a single line in a line-by-line diff:
+// a line kept from both files (' '), deleted from a ('-'), or inserted from b ('+').
+// The a and b fields are the 0-based line numbers in each file
+// at which the line appears or would appear.
+type diffOp struct {
+	kind byte
+	a, b int
+}
+
+// maxDiffEdits is the maximum number of edits lineDiff will compute.
+const maxDiffEdits = 5000
+
+// lineDiff returns a minimal line-by-line diff from a to b,
+// using the linear-space variant of Myers's O(ND) algorithm.
+// If the diff needs more than maxDiffEdits edits, lineDiff returns nil.
+func lineDiff(a, b []string) []diffOp {
+	ops := []diffOp{}
+	if !diffRange(&ops, a, b, 0, 0, maxDiffEdits) {
+		return nil
+	}
+
+	// Within each run of changes, list the deletions before the insertions.
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		x, y := ops[i].a, ops[i].b
+		j, nd := i, 0
+		for ; j < len(ops) && ops[j].kind != ' '; j++ {
+			if ops[j].kind == '-' {
+				nd++
+			}
+		}
+		for k := i; k < j; k++ {
+			if k-i < nd {
+				ops[k] = diffOp{'-', x + k - i, y}
+			} else {
+				ops[k] = diffOp{'+', x + nd, y + k - i - nd}
+			}
+		}
+		i = j
+	}
+	return ops
+}
+
+// diffRange appends to *ops a minimal diff from a to b,
+// which start at lines a0 and b0 of the files being compared.
+// It reports whether the diff needs at most max edits.
+func diffRange(ops *[]diffOp, a, b []string, a0, b0, max int) bool {
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		*ops = append(*ops, diffOp{' ', a0, b0})
+		a, b = a[1:], b[1:]
+		a0, b0 = a0+1, b0+1
+	}
+	suffix := 0
+	for suffix < len(a) && suffix < len(b) && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	a, b = a[:len(a)-suffix], b[:len(b)-suffix]
+
+	if len(a) == 0 || len(b) == 0 {
+		if len(a)+len(b) > max {
+			return false
+		}
+		for i := range a {
+			*ops = append(*ops, diffOp{'-', a0 + i, b0})
+		}
+		for j := range b {
+			*ops = append(*ops, diffOp{'+', a0, b0 + j})
+		}
+	} else {
+		x, y, u, v, ok := middleSnake(a, b, max)
+		if !ok {
+			return false
+		}
+		if !diffRange(ops, a[:x], b[:y], a0, b0, max) {
+			return false
+		}
+		for i := x; i < u; i++ {
+			*ops = append(*ops, diffOp{' ', a0 + i, b0 + y + i - x})
+		}
+		if !diffRange(ops, a[u:], b[v:], a0+u, b0+v, max) {
+			return false
+		}
+	}
+
+	for i := 0; i < suffix; i++ {
+		*ops = append(*ops, diffOp{' ', a0 + len(a) + i, b0 + len(b) + i})
+	}
+	return true
+}
+
+// middleSnake returns the middle snake of a minimal diff from a to b,
+// which must both be non-empty: the run of equal lines a[x:u] and b[y:v]
+// in the middle of the edit path, found by searching forward from the
+// start and backward from the end until the searches meet.
+// It reports false if the diff needs more than max edits.
+func middleSnake(a, b []string, max int) (x, y, u, v int, ok bool) {
+	n, m := len(a), len(b)
+	dmax := (n + m + 1) / 2
+	if dmax > (max+1)/2 {
+		dmax = (max + 1) / 2
+	}
+	delta := n - m
+	odd := delta&1 != 0
+	off := dmax + 1
+	vf := make([]int, 2*off+1) // vf[off+k] is the furthest x on diagonal k going forward
+	vb := make([]int, 2*off+1) // vb[off+k] is the same for the reversed files
+	for d := 0; d <= dmax; d++ {
+		for k := -d; k <= d; k += 2 {
+			if k == -d || k != d && vf[off+k-1] < vf[off+k+1] {
+				x = vf[off+k+1]
+			} else {
+				x = vf[off+k-1] + 1
+			}
+			y = x - k
+			u, v = x, y
+			for u < n && v < m && a[u] == b[v] {
+				u++
+				v++
+			}
+			vf[off+k] = u
+			// Diagonal k going forward is diagonal delta-k going backward.
+			if odd && delta-k >= -(d-1) && delta-k <= d-1 && u+vb[off+delta-k] >= n {
+				return x, y, u, v, true
+			}
+		}
+		for k := -d; k <= d; k += 2 {
+			var rx int
+			if k == -d || k != d && vb[off+k-1] < vb[off+k+1] {
+				rx = vb[off+k+1]
+			} else {
+				rx = vb[off+k-1] + 1
+			}
+			ry := rx - k
+			ru, rv := rx, ry
+			for ru < n && rv < m && a[n-1-ru] == b[m-1-rv] {
+				ru++
+				rv++
+			}
+			vb[off+k] = ru
+			if !odd && delta-k >= -d && delta-k <= d && ru+vf[off+delta-k] >= n {
+				return n - ru, m - rv, n - rx, m - ry, true
+			}
+		}
+	}
+	return 0, 0, 0, 0, false
+}
+
+// splitLines splits data into lines, without their trailing newlines.
+func splitLines(data []byte) []string {
+	s := strings.TrimSuffix(string(data), "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// writeUnified writes a unified diff of a and b to buf as HTML.
+// The hunk headers link to the lines in aURL and bURL.
+// It reports whether the diff could be computed.
+func writeUnified(buf *bytes.Buffer, a, b []string, aURL, bURL string) bool {
+	ops := lineDiff(a, b)
+	if ops == nil {
+		return false
+	}
+	e := html.EscapeString
+	for i := 0; i < len(ops); {
+		// Find next change and the extent of its hunk:
+		// changes separated by at most 2*diffContext unchanged lines are merged.
+		for i < len(ops) && ops[i].kind == ' ' {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != ' ' {
+				end = j + 1
+			} else if j-end >= 2*diffContext {
+				break
+			}
+		}
+		end += diffContext
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		na, nb := 0, 0
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				na++
+			}
+			if op.kind != '-' {
+				nb++
+			}
+		}
+		a0, b0 := ops[start].a+1, ops[start].b+1
+		fmt.Fprintf(buf, "<span class=\"hunk\">@@ <a href=\"%s#L%d\">-%d,%d</a> <a href=\"%s#L%d\">+%d,%d</a> @@</span>\n",
+			e(aURL), a0, a0, na, e(bURL), b0, b0, nb)
+		for _, op := range ops[start:end] {
+			switch op.kind {
+			case ' ':
+				fmt.Fprintf(buf, " %s\n", e(a[op.a]))
+			case '-':
+				fmt.Fprintf(buf, "<span class=\"del\">-%s</span>\n", e(a[op.a]))
+			case '+':
+				fmt.Fprintf(buf, "<span class=\"add\">+%s</span>\n", e(b[op.b]))
+			}
+		}
+		i = end
+	}
+	return true
+}
@@ -0,0 +1,374 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"html"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/mod/modfile"
+	"rsc.io/markdown"
+)
+
+// A dirInfo describes a directory in a module zip.
+type dirInfo struct {
+	Files []*zipEntry // files directly in the directory, sorted by name
+	Dirs  []string    // full paths of immediate subdirectories, sorted
+	Count int         // number of files in the directory tree
+	Size  uint64      // total uncompressed size of files in the tree
+}
+
+// buildTree builds the file and directory maps for ix.
+// Directories are keyed by full path within the zip,
+// such as "rsc.io/quote@v1.5.2" or "rsc.io/quote@v1.5.2/buggy".
+func (ix *zipIndex) buildTree() {
+	ix.files = make(map[string]*zipEntry)
+	ix.dirs = make(map[string]*dirInfo)
+	var get func(dir string) *dirInfo
+	get = func(dir string) *dirInfo {
+		d := ix.dirs[dir]
+		if d == nil {
+			d = new(dirInfo)
+			ix.dirs[dir] = d
+			if parent := path.Dir(dir); strings.Contains(parent, "@") {
+				pd := get(parent)
+				pd.Dirs = append(pd.Dirs, dir)
+			}
+		}
+		return d
+	}
+	for i := range ix.Files {
+		f := &ix.Files[i]
+		ix.files[f.Name] = f
+		d := get(path.Dir(f.Name))
+		d.Files = append(d.Files, f)
+		for dir := path.Dir(f.Name); strings.Contains(dir, "@"); dir = path.Dir(dir) {
+			d := ix.dirs[dir]
+			d.Count++
+			d.Size += f.UncompressedSize
+		}
+	}
+	for _, d := range ix.dirs {
+		sort.Slice(d.Files, func(i, j int) bool { return d.Files[i].Name < d.Files[j].Name })
+		sort.Strings(d.Dirs)
+	}
+}
+
+// lookup returns the entry for the named file, or nil if there is none.
+func (ix *zipIndex) lookup(name string) *zipEntry {
+	ix.treeOnce.Do(ix.buildTree)
+	return ix.files[name]
+}
+
+// dir returns the named directory, or nil if there is none.
+func (ix *zipIndex) dir(name string) *dirInfo {
+	ix.treeOnce.Do(ix.buildTree)
+	return ix.dirs[name]
+}
+
+// packageName returns the package name declared by the Go files in d,
+// preferring non-test files, or "" if there are none.
+func (ix *zipIndex) packageName(ctx context.Context, d *dirInfo) string {
+	var test *zipEntry
+	for _, f := range d.Files {
+		if !strings.HasSuffix(f.Name, ".go") {
+			continue
+		}
+		if strings.HasSuffix(f.Name, "_test.go") {
+			if test == nil {
+				test = f
+			}
+			continue
+		}
+		if name := ix.readPackageClause(ctx, f); name != "" {
+			return name
+		}
+	}
+	if test != nil {
+		return strings.TrimSuffix(ix.readPackageClause(ctx, test), "_test")
+	}
+	return ""
+}
+
+func (ix *zipIndex) readPackageClause(ctx context.Context, f *zipEntry) string {
+	if f.UncompressedSize > 1<<20 {
+		return ""
+	}
+	data, err := ix.readFile(ctx, f)
+	if err != nil {
+		return ""
+	}
+	pf, err := parser.ParseFile(token.NewFileSet(), f.Name, data, parser.PackageClauseOnly)
+	if err != nil {
+		return ""
+	}
+	return pf.Name.Name
+}
+
+// maxPackageNames is the maximum number of subdirectories
+// for which serveDir looks up package names.
+const maxPackageNames = 100
+
+// serveDir serves a listing of the directory d, which is mod@vers/file,
+// followed by the directory's go.mod summary and README, if any.
+func serveDir(ctx context.Context, ix *zipIndex, mod, vers, file string, d *dirInfo) *response {
+	var buf bytes.Buffer
+	e := html.EscapeString
+	check := ix.checkSum(ctx, mod, vers, false)
+	printHeader(&buf, mod, vers, file, check)
+
+	// Look up package names in parallel; each needs a read from the zip.
+	pkgs := make([]string, 1+len(d.Dirs))
+	var wg sync.WaitGroup
+	sema := make(chan bool, 8)
+	for i := 0; i < len(pkgs) && i <= maxPackageNames; i++ {
+		sd := d
+		if i > 0 {
+			sd = ix.dir(d.Dirs[i-1])
+		}
+		wg.Add(1)
+		sema <- true
+		go func(i int, sd *dirInfo) {
+			defer func() {
+				<-sema
+				wg.Done()
+			}()
+			pkgs[i] = ix.packageName(ctx, sd)
+		}(i, sd)
+	}
+	wg.Wait()
+
+	full := mod + "@" + vers
+	if file != "" {
+		full += "/" + file
+	}
+	lics := ix.dirLicenses(ctx, full)
+	writeLicenses(&buf, lics, full)
+	if pkgs[0] != "" {
+		doc := "@doc"
+		if file != "" {
+			doc = file + "/@doc"
+		}
+		fmt.Fprintf(&buf, "package %s <small>(<a href=\"/%s@%s/%s\">doc</a>)</small>\n\n", e(pkgs[0]), e(mod), e(vers), e(doc))
+	} else if len(lics) > 0 {
+		buf.WriteString("\n")
+	}
+
+	wid := 0
+	for _, sub := range d.Dirs {
+		if n := len(path.Base(sub)) + 1; n > wid {
+			wid = n
+		}
+	}
+	for _, f := range d.Files {
+		if n := len(path.Base(f.Name)); n > wid {
+			wid = n
+		}
+	}
+	for i, sub := range d.Dirs {
+		sd := ix.dir(sub)
+		elem := path.Base(sub) + "/"
+		files := "files"
+		if sd.Count == 1 {
+			files = "file"
+		}
+		fmt.Fprintf(&buf, "<a href=\"/%s\">%s</a>%*s  %8s  %d %s", e(sub), e(elem), wid-len(elem), "", formatSize(sd.Size), sd.Count, files)
+		if pkgs[i+1] != "" {
+			fmt.Fprintf(&buf, "  package %s", e(pkgs[i+1]))
+		}
+		buf.WriteString("\n")
+	}
+	if len(d.Dirs) > 0 && len(d.Files) > 0 {
+		buf.WriteString("\n")
+	}
+	var gomod, readme *zipEntry
+	for _, f := range d.Files {
+		elem := path.Base(f.Name)
+		fmt.Fprintf(&buf, "<a href=\"/%s\">%s</a>%*s  %8s\n", e(f.Name), e(elem), wid-len(elem), "", formatSize(f.UncompressedSize))
+		switch {
+		case elem == "go.mod":
+			gomod = f
+		case strings.EqualFold(elem, "README.md") && readme == nil:
+			readme = f
+		}
+	}
+
+	if gomod != nil {
+		writeGoMod(ctx, &buf, ix, gomod)
+	}
+	if readme != nil {
+		writeReadme(ctx, &buf, ix, readme, mod, vers, file)
+	}
+	return pageResponse(&buf, check)
+}
+
+// formatSize returns a short human-readable form of a size in bytes.
+func formatSize(n uint64) string {
+	switch {
+	case n < 1<<10:
+		return fmt.Sprintf("%d B", n)
+	case n < 1<<20:
+		return fmt.Sprintf("%.1f kB", float64(n)/(1<<10))
+	case n < 1<<30:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	}
+	return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+}
+
+// writeGoMod writes a summary of the go.mod file f:
+// the module path, the Go version, and the requirements,
+// which link to the required module versions in the viewer.
+func writeGoMod(ctx context.Context, buf *bytes.Buffer, ix *zipIndex, f *zipEntry) {
+	if f.UncompressedSize > 1<<20 {
+		return
+	}
+	data, err := ix.readFile(ctx, f)
+	if err != nil {
+		return
+	}
+	mf, err := modfile.ParseLax(f.Name, data, nil)
+	if err != nil || mf.Module == nil {
+		return
+	}
+	e := html.EscapeString
+	fmt.Fprintf(buf, "\n<b>go.mod</b> <small>(<a href=\"/%s/@deps\">dependency graph</a>)</small>\n\n", e(path.Dir(f.Name)))
+	fmt.Fprintf(buf, "module %s\n", e(mf.Module.Mod.Path))
+	if mf.Go != nil {
+		fmt.Fprintf(buf, "go %s\n", e(mf.Go.Version))
+	}
+	if len(mf.Require) > 0 {
+		buf.WriteString("\n")
+	}
+	for _, r := range mf.Require {
+		fmt.Fprintf(buf, "require <a href=\"/%s@%s\">%s %s</a>", e(r.Mod.Path), e(r.Mod.Version), e(r.Mod.Path), e(r.Mod.Version))
+		if r.Indirect {
+			buf.WriteString(" // indirect")
+		}
+		buf.WriteString("\n")
+	}
+}
+
+// writeReadme renders the Markdown file f as HTML,
+// with raw HTML removed and relative links resolved
+// against the directory mod@vers/dir.
+func writeReadme(ctx context.Context, buf *bytes.Buffer, ix *zipIndex, f *zipEntry, mod, vers, dir string) {
+	if f.UncompressedSize > 1<<20 {
+		return
+	}
+	data, err := ix.readFile(ctx, f)
+	if err != nil || !isText(data) {
+		return
+	}
+	p := &markdown.Parser{
+		HeadingIDs:         true,
+		Strikethrough:      true,
+		TaskListItems:      true,
+		AutoLinkText:       true,
+		AutoLinkAssumeHTTP: true,
+		Table:              true,
+		Emoji:              true,
+	}
+	doc := p.Parse(string(data))
+	base := "/" + mod + "@" + vers + "/"
+	if dir != "" {
+		base += dir + "/"
+	}
+	sanitizeBlocks(doc.Blocks, base)
+	fmt.Fprintf(buf, "\n<b>%s</b>\n</pre>\n<div class=\"readme\">\n", html.EscapeString(path.Base(f.Name)))
+	buf.WriteString(markdown.ToHTML(doc))
+	buf.WriteString("</div>\n<pre>\n")
+}
+
+// sanitizeBlocks removes raw HTML from the Markdown blocks
+// and rewrites their link and image URLs using sanitizeURL.
+func sanitizeBlocks(blocks []markdown.Block, base string) {
+	for i, b := range blocks {
+		switch b := b.(type) {
+		case *markdown.HTMLBlock:
+			blocks[i] = &markdown.Empty{}
+		case *markdown.Paragraph:
+			sanitizeText(b.Text, base)
+		case *markdown.Heading:
+			sanitizeText(b.Text, base)
+		case *markdown.Quote:
+			sanitizeBlocks(b.Blocks, base)
+		case *markdown.List:
+			sanitizeBlocks(b.Items, base)
+		case *markdown.Item:
+			sanitizeBlocks(b.Blocks, base)
+		case *markdown.Table:
+			for _, t := range b.Header {
+				sanitizeText(t, base)
+			}
+			for _, row := range b.Rows {
+				for _, t := range row {
+					sanitizeText(t, base)
+				}
+			}
+		}
+	}
+}
+
+func sanitizeText(t *markdown.Text, base string) {
+	if t != nil {
+		sanitizeInlines(t.Inline, base)
+	}
+}
+
+func sanitizeInlines(inlines []markdown.Inline, base string) {
+	for i, x := range inlines {
+		switch x := x.(type) {
+		case *markdown.HTMLTag:
+			inlines[i] = &markdown.Plain{Text: x.Text}
+		case *markdown.Strong:
+			sanitizeInlines(x.Inner, base)
+		case *markdown.Emph:
+			sanitizeInlines(x.Inner, base)
+		case *markdown.Del:
+			sanitizeInlines(x.Inner, base)
+		case *markdown.Link:
+			x.URL = sanitizeURL(x.URL, base)
+			sanitizeInlines(x.Inner, base)
+		case *markdown.Image:
+			x.URL = sanitizeURL(x.URL, base)
+			sanitizeInlines(x.Inner, base)
+		case *markdown.AutoLink:
+			x.URL = sanitizeURL(x.URL, base)
+		}
+	}
+}
+
+// sanitizeURL returns u if it is an http, https or mailto URL
+// or a fragment, resolves other relative URLs against base,
+// and returns "" for anything else.
+func sanitizeURL(u, base string) string {
+	p, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+	switch p.Scheme {
+	case "http", "https", "mailto":
+		return u
+	case "":
+		if strings.HasPrefix(u, "#") || p.Host != "" {
+			return u
+		}
+		if strings.HasPrefix(p.Path, "/") {
+			// Absolute paths refer to the repository root, which we cannot know.
+			return ""
+		}
+		return (&url.URL{Path: path.Clean(base + p.Path), RawQuery: p.RawQuery, Fragment: p.Fragment}).String()
+	}
+	return ""
+}
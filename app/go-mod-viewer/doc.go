@@ -0,0 +1,197 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/doc"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"html"
+	"net/http"
+	"strings"
+)
+
+// A goPackage is a Go package parsed from a module zip.
+type goPackage struct {
+	fset  *token.FileSet
+	files map[string]*ast.File // by full zip name
+	doc   *doc.Package         // exported API only
+}
+
+// loadPackage parses the non-test Go files in the directory mod@vers/dir
+// and returns the package they declare, or nil if there is none.
+// If the files declare more than one package, loadPackage uses
+// the one named by the first file, as packageName does.
+func loadPackage(ctx context.Context, ix *zipIndex, mod, vers, dir string) (*goPackage, error) {
+	full := mod + "@" + vers
+	importPath := mod
+	if dir != "" {
+		full += "/" + dir
+		importPath += "/" + dir
+	}
+	d := ix.dir(full)
+	if d == nil {
+		return nil, nil
+	}
+	p := &goPackage{fset: token.NewFileSet(), files: make(map[string]*ast.File)}
+	var list []*ast.File
+	pkg := ""
+	for _, zf := range d.Files {
+		if !strings.HasSuffix(zf.Name, ".go") || strings.HasSuffix(zf.Name, "_test.go") || zf.UncompressedSize > 1<<20 {
+			continue
+		}
+		data, err := ix.readFile(ctx, zf)
+		if err != nil {
+			return nil, err
+		}
+		f, err := parser.ParseFile(p.fset, zf.Name, data, parser.ParseComments|parser.SkipObjectResolution)
+		if err != nil {
+			continue
+		}
+		if pkg == "" {
+			pkg = f.Name.Name
+		}
+		if f.Name.Name != pkg {
+			continue
+		}
+		p.files[zf.Name] = f
+		list = append(list, f)
+	}
+	if len(list) == 0 {
+		return nil, nil
+	}
+	var err error
+	p.doc, err = doc.NewFromFiles(p.fset, list, importPath)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// declText returns the Go source for the declaration decl, without a function body.
+func (p *goPackage) declText(decl ast.Decl) string {
+	if fn, ok := decl.(*ast.FuncDecl); ok && fn.Body != nil {
+		copy := *fn
+		copy.Body = nil
+		decl = &copy
+	}
+	var node any = decl
+	if f := p.files[p.fset.Position(decl.Pos()).Filename]; f != nil {
+		node = &printer.CommentedNode{Node: decl, Comments: f.Comments}
+	}
+	var buf bytes.Buffer
+	cfg := printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+	if err := cfg.Fprint(&buf, p.fset, node); err != nil {
+		return fmt.Sprintf("<%v>", err)
+	}
+	return buf.String()
+}
+
+// sourceLink returns the viewer URL of the source line for pos.
+func (p *goPackage) sourceLink(pos token.Pos) string {
+	position := p.fset.Position(pos)
+	return fmt.Sprintf("/%s#L%d", position.Filename, position.Line)
+}
+
+// serveDoc serves the API documentation for the package in mod@vers/dir:
+// the package comment followed by the exported constants, variables,
+// functions and types, each linked to its declaration in the source.
+func serveDoc(ctx context.Context, ix *zipIndex, mod, vers, dir string) *response {
+	p, err := loadPackage(ctx, ix, mod, vers, dir)
+	if err != nil {
+		return errorResponse(http.StatusBadGateway, "i/o error: %v", err)
+	}
+	if p == nil {
+		return errorResponse(http.StatusNotFound, "No Go package.")
+	}
+	file := "@doc"
+	if dir != "" {
+		file = dir + "/@doc"
+	}
+	var buf bytes.Buffer
+	e := html.EscapeString
+	check := ix.checkSum(ctx, mod, vers, false)
+	printHeader(&buf, mod, vers, file, check)
+
+	pkg := p.doc
+	fmt.Fprintf(&buf, "package %s // import %q\n</pre>\n", e(pkg.Name), e(pkg.ImportPath))
+	pr := pkg.Printer()
+	pr.HeadingLevel = 3
+	pr.DocLinkBaseURL = "https://pkg.go.dev"
+	comment := func(text string) {
+		if text != "" {
+			buf.WriteString("<div class=\"doc\">\n")
+			buf.Write(pr.HTML(pkg.Parser().Parse(text)))
+			buf.WriteString("</div>\n")
+		}
+	}
+	decl := func(id, kind string, name *ast.Ident, d ast.Decl, text string) {
+		fmt.Fprintf(&buf, "<h4 id=\"%s\">%s <a href=\"%s\">%s</a></h4>\n", e(id), kind, e(p.sourceLink(name.Pos())), e(id))
+		fmt.Fprintf(&buf, "<pre>%s</pre>\n", e(p.declText(d)))
+		comment(text)
+	}
+	values := func(list []*doc.Value) {
+		for _, v := range list {
+			fmt.Fprintf(&buf, "<pre><a href=\"%s\">%s</a></pre>\n", e(p.sourceLink(v.Decl.Pos())), e(p.declText(v.Decl)))
+			comment(v.Doc)
+		}
+	}
+	funcs := func(list []*doc.Func) {
+		for _, f := range list {
+			id, kind := f.Name, "func"
+			if f.Recv != "" {
+				// Anchor methods as pkgsite does: T.M, for receiver *T or T[P].
+				recv, _, _ := strings.Cut(strings.TrimPrefix(f.Recv, "*"), "[")
+				id, kind = recv+"."+f.Name, "method"
+			}
+			decl(id, kind, f.Decl.Name, f.Decl, f.Doc)
+		}
+	}
+
+	comment(pkg.Doc)
+	if len(pkg.Consts) > 0 {
+		buf.WriteString("<h3>Constants</h3>\n")
+		values(pkg.Consts)
+	}
+	if len(pkg.Vars) > 0 {
+		buf.WriteString("<h3>Variables</h3>\n")
+		values(pkg.Vars)
+	}
+	if len(pkg.Funcs) > 0 {
+		buf.WriteString("<h3>Functions</h3>\n")
+		funcs(pkg.Funcs)
+	}
+	if len(pkg.Types) > 0 {
+		buf.WriteString("<h3>Types</h3>\n")
+	}
+	for _, t := range pkg.Types {
+		var name *ast.Ident
+		for _, spec := range t.Decl.Specs {
+			if ts, ok := spec.(*ast.TypeSpec); ok && ts.Name.Name == t.Name {
+				name = ts.Name
+			}
+		}
+		if name == nil {
+			continue
+		}
+		decl(t.Name, "type", name, t.Decl, t.Doc)
+		values(t.Consts)
+		values(t.Vars)
+		funcs(t.Funcs)
+		funcs(t.Methods)
+	}
+	if len(pkg.Notes["BUG"]) > 0 {
+		buf.WriteString("<h3>Bugs</h3>\n")
+		for _, n := range pkg.Notes["BUG"] {
+			comment(n.Body)
+		}
+	}
+	return pageResponse(&buf, check)
+}
@@ -0,0 +1,169 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A mark annotates the source bytes [start, end) with a CSS class and an optional link.
+type mark struct {
+	start, end int
+	class      string
+	href       string
+}
+
+// goMarks returns the syntax highlighting and cross-reference marks
+// for the Go source file mod@vers/file, sorted by position.
+// Identifiers are linked to their declarations in the same file
+// or in other files of the same package in the module zip.
+// If the file does not parse, goMarks returns ok=false.
+func goMarks(ctx context.Context, ix *zipIndex, mod, vers, file string, data []byte) (marks []mark, ok bool) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, file, data, parser.ParseComments)
+	if err != nil {
+		return nil, false
+	}
+	tf := fset.File(f.Pos())
+
+	// Identifier links, by offset.
+	// The parser has already resolved identifiers declared in this file;
+	// the ones it could not resolve may be declared in other files of the package.
+	links := make(map[int]string)
+	unresolved := make(map[*ast.Ident]bool)
+	for _, id := range f.Unresolved {
+		unresolved[id] = true
+	}
+	var pkgDecls map[string]string
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Ident:
+			if n.Obj != nil {
+				if p := n.Obj.Pos(); p.IsValid() && p != n.Pos() {
+					links[tf.Offset(n.Pos())] = fmt.Sprintf("#L%d", tf.Line(p))
+				}
+			} else if unresolved[n] {
+				if pkgDecls == nil {
+					pkgDecls = packageDecls(ctx, ix, mod, vers, path.Dir(file), f.Name.Name)
+				}
+				if href, ok := pkgDecls[n.Name]; ok {
+					links[tf.Offset(n.Pos())] = href
+				}
+			}
+		case *ast.ImportSpec:
+			// Link imports of packages in this module to their directories.
+			p, err := strconv.Unquote(n.Path.Value)
+			if err == nil && (p == mod || strings.HasPrefix(p, mod+"/")) {
+				links[tf.Offset(n.Path.Pos())] = "/" + mod + "@" + vers + strings.TrimPrefix(p, mod)
+			}
+		}
+		return true
+	})
+
+	var s scanner.Scanner
+	s.Init(tf, data, nil, scanner.ScanComments)
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		off := tf.Offset(pos)
+		var class string
+		switch {
+		case tok == token.COMMENT:
+			class = "com"
+		case tok == token.STRING || tok == token.CHAR:
+			class = "str"
+		case tok == token.INT || tok == token.FLOAT || tok == token.IMAG:
+			class = "num"
+		case tok.IsKeyword():
+			class = "kw"
+		case tok == token.IDENT && links[off] != "":
+			class = "ref"
+		default:
+			continue
+		}
+		marks = append(marks, mark{start: off, end: litEnd(data, off, lit), class: class, href: links[off]})
+	}
+	sort.Slice(marks, func(i, j int) bool { return marks[i].start < marks[j].start })
+	return marks, true
+}
+
+// litEnd returns the offset just past the source of the token lit,
+// which starts at data[off].
+// The scanner drops carriage returns from raw strings and comments,
+// so the source can be longer than lit.
+func litEnd(data []byte, off int, lit string) int {
+	i := off
+	for j := 0; j < len(lit) && i < len(data); i++ {
+		if data[i] == lit[j] {
+			j++
+		} else if data[i] != '\r' {
+			break
+		}
+	}
+	return i
+}
+
+// packageDecls returns links to the package-level declarations
+// in the Go files for package pkg in the directory mod@vers/dir,
+// keyed by declared name.
+func packageDecls(ctx context.Context, ix *zipIndex, mod, vers, dir, pkg string) map[string]string {
+	prefix := mod + "@" + vers + "/"
+	if dir != "." {
+		prefix += dir + "/"
+	}
+	decls := make(map[string]string)
+	fset := token.NewFileSet()
+	for i := range ix.Files {
+		zf := &ix.Files[i]
+		name := strings.TrimPrefix(zf.Name, prefix)
+		if len(name) == len(zf.Name) || strings.Contains(name, "/") || !strings.HasSuffix(name, ".go") || zf.UncompressedSize > 1<<20 {
+			continue
+		}
+		data, err := ix.readFile(ctx, zf)
+		if err != nil {
+			continue
+		}
+		f, err := parser.ParseFile(fset, name, data, parser.SkipObjectResolution)
+		if err != nil || f.Name.Name != pkg {
+			continue
+		}
+		add := func(id *ast.Ident) {
+			if id.Name != "_" {
+				decls[id.Name] = fmt.Sprintf("/%s%s#L%d", prefix, name, fset.Position(id.Pos()).Line)
+			}
+		}
+		for _, d := range f.Decls {
+			switch d := d.(type) {
+			case *ast.FuncDecl:
+				if d.Recv == nil && d.Name.Name != "init" {
+					add(d.Name)
+				}
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						add(spec.Name)
+					case *ast.ValueSpec:
+						for _, id := range spec.Names {
+							add(id)
+						}
+					}
+				}
+			}
+		}
+	}
+	return decls
+}
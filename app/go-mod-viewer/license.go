@@ -0,0 +1,241 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"html"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// The licenses directory holds the texts of common licenses,
+// named by SPDX identifier, against which license files are classified.
+//
+//go:embed licenses
+var licenseFS embed.FS
+
+// A licenseText is a known license, as a set of hashed word trigrams.
+type licenseText struct {
+	id    string
+	grams map[uint64]bool
+}
+
+var licenseTexts struct {
+	once sync.Once
+	list []*licenseText
+}
+
+// knownLicenses returns the bundled licenses.
+func knownLicenses() []*licenseText {
+	licenseTexts.once.Do(func() {
+		files, err := licenseFS.ReadDir("licenses")
+		if err != nil {
+			panic(err)
+		}
+		for _, f := range files {
+			data, err := licenseFS.ReadFile("licenses/" + f.Name())
+			if err != nil {
+				panic(err)
+			}
+			id := strings.TrimSuffix(f.Name(), ".txt")
+			licenseTexts.list = append(licenseTexts.list, &licenseText{id, wordTrigrams(data)})
+		}
+	})
+	return licenseTexts.list
+}
+
+// wordTrigrams returns the set of hashes of the three-word sequences in text,
+// ignoring case, punctuation and spacing.
+func wordTrigrams(text []byte) map[uint64]bool {
+	words := strings.FieldsFunc(strings.ToLower(string(text)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	grams := make(map[uint64]bool)
+	for i := 0; i+3 <= len(words); i++ {
+		h := fnv.New64a()
+		fmt.Fprintf(h, "%s %s %s", words[i], words[i+1], words[i+2])
+		grams[h.Sum64()] = true
+	}
+	return grams
+}
+
+// A licenseMatch is the result of classifying a license file.
+type licenseMatch struct {
+	File     string  // full name in the zip
+	ID       string  // SPDX identifier, or "" if unknown
+	Coverage float64 // fraction of the license text found in the file
+}
+
+// minLicenseCoverage is the fraction of a known license's text
+// that a file must contain to be classified as that license.
+const minLicenseCoverage = 0.75
+
+// classifyLicense returns the known license that data contains.
+// Of the licenses sufficiently covered by data, it picks the one
+// with the most matching text, so that, for example, a BSD-3-Clause file,
+// which contains all of BSD-2-Clause, is reported as BSD-3-Clause.
+func classifyLicense(data []byte) (id string, coverage float64) {
+	grams := wordTrigrams(data)
+	best := 0
+	for _, l := range knownLicenses() {
+		n := 0
+		for g := range l.grams {
+			if grams[g] {
+				n++
+			}
+		}
+		c := float64(n) / float64(len(l.grams))
+		if c >= minLicenseCoverage && n > best {
+			id, coverage, best = l.id, c, n
+		}
+	}
+	return id, coverage
+}
+
+// isLicenseFile reports whether name looks like the name of a license file,
+// such as LICENSE, COPYING.txt or LICENSE-MIT.
+func isLicenseFile(name string) bool {
+	stem := strings.ToUpper(path.Base(name))
+	switch path.Ext(stem) {
+	case ".TXT", ".MD", ".RST", ".MARKDOWN":
+		stem = strings.TrimSuffix(stem, path.Ext(stem))
+	}
+	for _, p := range []string{"LICENSE", "LICENCE", "COPYING", "COPYRIGHT", "UNLICENSE"} {
+		if stem == p || strings.HasPrefix(stem, p+"-") || strings.HasPrefix(stem, p+".") || strings.HasSuffix(stem, "-"+p) {
+			return true
+		}
+	}
+	return false
+}
+
+// maxLicenseFile is the size of the largest file classifyLicense is applied to.
+const maxLicenseFile = 256 << 10
+
+// licenses returns the classified license files directly in the directory d.
+func (ix *zipIndex) licenses(ctx context.Context, d *dirInfo) []licenseMatch {
+	var list []licenseMatch
+	for _, f := range d.Files {
+		if !isLicenseFile(f.Name) {
+			continue
+		}
+		m := licenseMatch{File: f.Name}
+		if f.UncompressedSize <= maxLicenseFile {
+			if data, err := ix.readFile(ctx, f); err == nil && isText(data) {
+				m.ID, m.Coverage = classifyLicense(data)
+			}
+		}
+		list = append(list, m)
+	}
+	return list
+}
+
+// dirLicenses returns the license files that apply to the directory dir,
+// a full path in the zip: those in dir itself or, if there are none,
+// in the nearest parent directory that has any.
+func (ix *zipIndex) dirLicenses(ctx context.Context, dir string) []licenseMatch {
+	for ; strings.Contains(dir, "@"); dir = path.Dir(dir) {
+		if d := ix.dir(dir); d != nil {
+			if list := ix.licenses(ctx, d); len(list) > 0 {
+				return list
+			}
+		}
+	}
+	return nil
+}
+
+// writeLicenses writes a one-line summary of the licenses in list,
+// each linking to its file, noting the directory if it is not dir.
+func writeLicenses(buf *bytes.Buffer, list []licenseMatch, dir string) {
+	if len(list) == 0 {
+		return
+	}
+	e := html.EscapeString
+	buf.WriteString("license")
+	for i, m := range list {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		id := m.ID
+		if id == "" {
+			id = "unknown"
+		}
+		fmt.Fprintf(buf, " <a href=\"/%s\">%s</a>", e(m.File), e(id))
+	}
+	if from := path.Dir(list[0].File); from != dir {
+		fmt.Fprintf(buf, " <small>(from <a href=\"/%s\">%s</a>)</small>", e(from), e(from))
+	}
+	buf.WriteString("\n")
+}
+
+// serveLicenses serves a summary of the license files anywhere in mod@vers,
+// as HTML or, when the format parameter is "json", as JSON.
+func serveLicenses(ctx context.Context, ix *zipIndex, mod, vers string, query url.Values) *response {
+	var list []licenseMatch
+	ix.dir(mod + "@" + vers) // build tree
+	var walk func(dir string)
+	walk = func(dir string) {
+		d := ix.dir(dir)
+		if d == nil {
+			return
+		}
+		list = append(list, ix.licenses(ctx, d)...)
+		for _, sub := range d.Dirs {
+			walk(sub)
+		}
+	}
+	walk(mod + "@" + vers)
+
+	switch query.Get("format") {
+	case "json":
+		if list == nil {
+			list = []licenseMatch{}
+		}
+		data, err := json.MarshalIndent(list, "", "\t")
+		if err != nil {
+			return errorResponse(http.StatusInternalServerError, "%v", err)
+		}
+		return &response{Status: http.StatusOK, ContentType: "application/json", Body: append(data, '\n')}
+	case "":
+		// HTML
+	default:
+		return errorResponse(http.StatusBadRequest, "Unknown format %q.", query.Get("format"))
+	}
+
+	var buf bytes.Buffer
+	e := html.EscapeString
+	check := ix.checkSum(ctx, mod, vers, false)
+	printHeader(&buf, mod, vers, "@licenses", check)
+	fmt.Fprintf(&buf, "<small>(<a href=\"/%s@%s/@licenses?format=json\">json</a>)</small>\n\n", e(mod), e(vers))
+	if len(list) == 0 {
+		buf.WriteString("No license files found.\n")
+		return pageResponse(&buf, check)
+	}
+	prefix := mod + "@" + vers + "/"
+	wid := 0
+	for _, m := range list {
+		if n := len(strings.TrimPrefix(m.File, prefix)); n > wid {
+			wid = n
+		}
+	}
+	for _, m := range list {
+		name := strings.TrimPrefix(m.File, prefix)
+		id := "unknown"
+		if m.ID != "" {
+			id = fmt.Sprintf("%s (%.0f%% match)", m.ID, 100*m.Coverage)
+		}
+		fmt.Fprintf(&buf, "<a href=\"/%s\">%s</a>%*s  %s\n", e(m.File), e(name), wid-len(name), "", e(id))
+	}
+	return pageResponse(&buf, check)
+}
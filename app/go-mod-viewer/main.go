@@ -5,32 +5,40 @@
 package main
 
 import (
-	"archive/zip"
+	"bufio"
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"embed"
+	"encoding/gob"
 	"fmt"
 	"html"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
-	"path"
 	"runtime"
-	"strconv"
 	"strings"
 	"time"
 	"unicode/utf8"
 
 	"golang.org/x/mod/module"
 	"google.golang.org/appengine/v2"
-	"google.golang.org/appengine/v2/memcache"
 )
 
 //go:embed index.html viewer.*
 var static embed.FS
 
 func main() {
+	c, err := newCache(os.Getenv("VIEWER_CACHE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	viewerCache = c
+	if viewVersion == "" {
+		viewVersion = time.Now().Format(time.RFC3339Nano)
+	}
 	http.HandleFunc("/.info", info)
 	http.HandleFunc("/", modViewer)
 	log.Fatal(http.ListenAndServe(":"+os.Getenv("PORT"), nil))
@@ -41,6 +49,11 @@ func info(w http.ResponseWriter, r *http.Request) {
 }
 
 var deployID = os.Getenv("GAE_DEPLOYMENT_ID")
+
+// viewVersion distinguishes rendered pages from different versions of the viewer.
+// Outside App Engine, each process is its own version.
+var viewVersion = deployID
+
 var staticHandler http.Handler = http.FileServer(http.FS(static))
 
 func modViewer(w http.ResponseWriter, r *http.Request) {
@@ -53,33 +66,48 @@ func modViewer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if deployID == "" {
-		w.Write(serve(r.URL.Path))
-		return
+	ctx := r.Context()
+	if deployID != "" {
+		ctx = appengine.NewContext(r)
 	}
-
-	ctx := appengine.NewContext(r)
-	sum := sha256.Sum256([]byte(r.URL.Path + "#" + deployID))
+	sum := sha256.Sum256([]byte(r.URL.Path + "?" + r.URL.RawQuery + "#" + viewVersion))
 	key := fmt.Sprintf("view.%x", sum[:])
-	item, err := memcache.Get(ctx, key)
-	if err != nil {
-		data := serve(r.URL.Path)
-		item = &memcache.Item{
-			Key:        key,
-			Value:      data,
-			Expiration: 15 * time.Minute,
+	var resp *response
+	if data, err := viewerCache.Get(ctx, key); err == nil {
+		resp = new(response)
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(resp); err != nil {
+			log.Printf("%s: bad cached response: %v", r.URL.Path, err)
+			resp = nil
 		}
-		if err := memcache.Set(ctx, item); err != nil {
+	}
+	if resp == nil {
+		// The raw file content is at /raw/mod@vers/file or mod@vers/file?raw=1.
+		urlPath, query := r.URL.Path, r.URL.Query()
+		raw := query.Get("raw") == "1"
+		if strings.HasPrefix(urlPath, "/raw/") {
+			urlPath, raw = strings.TrimPrefix(urlPath, "/raw"), true
+		}
+		resp = serve(ctx, urlPath, query, raw)
+		ttl := viewTTL
+		if resp.Volatile {
+			ttl = volatileTTL
+		}
+		var buf bytes.Buffer
+		if resp.Stream != nil {
+			// Not cacheable.
+		} else if err := gob.NewEncoder(&buf).Encode(resp); err != nil {
+			log.Printf("%s: encoding response: %v", r.URL.Path, err)
+		} else if err := viewerCache.Set(ctx, key, buf.Bytes(), ttl); err != nil {
 			log.Print(err)
 		}
 	}
-	w.Write(item.Value)
+	resp.write(w, r)
 }
 
-func serve(urlPath string) []byte {
+func serve(ctx context.Context, urlPath string, query url.Values, raw bool) *response {
 	i := strings.Index(urlPath, "@")
 	if i < 0 {
-		return []byte("Page not found.\n")
+		return errorResponse(http.StatusNotFound, "Page not found.")
 	}
 	mod, file := "", ""
 	j := strings.Index(urlPath[i:], "/")
@@ -91,110 +119,63 @@ func serve(urlPath string) []byte {
 	mod, vers, _ := strings.Cut(mod, "@")
 	mod = strings.TrimPrefix(mod, "/")
 
-	epath, err := module.EscapePath(mod)
-	if err != nil {
-		return []byte("Invalid module path: " + mod + "\n")
+	// Following the proxy protocol, mod/@v lists versions,
+	// and mod/@v1..v2 is accepted as well as mod@v1..v2 for diffs.
+	mod = strings.TrimSuffix(mod, "/")
+	if vers == "v" && file == "" {
+		return serveVersions(mod)
 	}
-	evers, err := module.EscapeVersion(vers)
-	if err != nil {
-		return []byte("Invalid module version.\n")
+	if v1, v2, ok := strings.Cut(vers, ".."); ok {
+		return serveDiff(ctx, mod, v1, v2, file, query)
 	}
-	name := epath + "/@v/" + evers + ".zip"
-	url := "https://proxy.golang.org/" + name
 
-	req, err := http.NewRequest("HEAD", url, nil)
-	req.Header.Set("Disable-Module-Fetch", "true")
-	resp, err := http.DefaultClient.Do(req)
+	epath, err := module.EscapePath(mod)
 	if err != nil {
-		return []byte(fmt.Sprintf("%s: HTTP error: %v\n", url, err))
-	}
-	resp.Body.Close()
-	if resp.StatusCode != 200 {
-		return []byte(fmt.Sprintf("%s: HTTP error: %v\n", url, resp.Status))
+		return errorResponse(http.StatusBadRequest, "Invalid module path: %s", mod)
 	}
-	if resp.Header.Get("Accept-Ranges") != "bytes" {
-		return []byte(fmt.Sprintf("%s: bad Accept-Range: %v", url, resp.Header.Get("Accept-Ranges")))
-	}
-	size, err := strconv.ParseUint(resp.Header.Get("Content-Length"), 10, 64)
+	evers, err := module.EscapeVersion(vers)
 	if err != nil {
-		return []byte(fmt.Sprintf("%s: bad Content-Length: %v", url, err))
-	}
-	rc := &remoteReaderAt{
-		url:  url,
-		size: int64(size),
+		return errorResponse(http.StatusBadRequest, "Invalid module version.")
 	}
-	r, err := zip.NewReader(rc, int64(size))
+	ix, err := loadIndex(ctx, epath+"/@v/"+evers+".zip")
 	if err != nil {
-		return []byte(fmt.Sprintf("%s: %v", url, err))
-	}
-
-	var dir []string
-	full := mod + "@" + vers + "/" + file
-	fslash := full
-	if file != "" {
-		fslash += "/"
-	}
-	have := make(map[string]bool)
-	for _, f := range r.File {
-		if strings.HasPrefix(f.Name, fslash) {
-			elem, _, _ := strings.Cut(f.Name[len(fslash):], "/")
-			if !have[elem] {
-				have[elem] = true
-				dir = append(dir, fslash+elem)
-			}
-		}
-	}
-	if len(dir) > 0 {
-		return serveDir(mod, vers, file, dir)
+		return proxyErrorResponse(err)
 	}
 
-	for _, f := range r.File {
-		if f.Name == full {
-			return serveFile(mod, vers, file, f)
-		}
-	}
-
-	return []byte("Not found.\n")
-}
-
-type remoteReaderAt struct {
-	url  string
-	size int64
+	resp := serveVersion(ctx, ix, mod, vers, file, query, raw)
+	resp.setVersioned(urlPath, query.Encode(), ix.sum(ctx))
+	return resp
 }
 
-func (r *remoteReaderAt) ReadAt(b []byte, off int64) (int, error) {
-	n := r.size - off
-	if n > int64(len(b)) {
-		n = int64(len(b))
-	}
-	b = b[:n]
-	req, err := http.NewRequest("GET", r.url, nil)
-	req.Header.Set("Disable-Module-Fetch", "true")
-	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+n-1))
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return 0, err
+// serveVersion serves the page or file for mod@vers/file, whose zip index is ix.
+func serveVersion(ctx context.Context, ix *zipIndex, mod, vers, file string, query url.Values, raw bool) *response {
+	if file == "@search" {
+		return serveSearch(ctx, ix, mod, vers, query)
 	}
-	if resp.StatusCode != 206 {
-		resp.Body.Close()
-		return 0, fmt.Errorf("%s: %s", r.url, resp.Status)
+	if file == "@licenses" {
+		return serveLicenses(ctx, ix, mod, vers, query)
 	}
-	if resp.Header.Get("Content-Length") != fmt.Sprint(n) {
-		resp.Body.Close()
-		return 0, fmt.Errorf("%s: bad Content-Length: %v != %v", r.url, resp.Header.Get("Content-Length"), n)
+	if file == "@checksum" {
+		return serveChecksum(ctx, ix, mod, vers)
 	}
-	data, err := io.ReadAll(resp.Body)
-	resp.Body.Close()
-	if err != nil {
-		return 0, fmt.Errorf("%s: reading body: %v", r.url, err)
+	if file == "@deps" {
+		return serveDeps(ctx, mod, vers, query)
+	}
+	if file == "@doc" || strings.HasSuffix(file, "/@doc") {
+		return serveDoc(ctx, ix, mod, vers, strings.TrimSuffix(strings.TrimSuffix(file, "@doc"), "/"))
 	}
-	if int64(len(data)) != n {
-		return 0, fmt.Errorf("%s: unexpected data length %v != %v", r.url, len(data), n)
+
+	full := mod + "@" + vers + "/" + file
+	if d := ix.dir(strings.TrimSuffix(full, "/")); d != nil {
+		return serveDir(ctx, ix, mod, vers, file, d)
+	}
+	if f := ix.lookup(full); f != nil {
+		return serveFile(ctx, ix, mod, vers, file, f, raw)
 	}
-	return copy(b, data), nil
+	return errorResponse(http.StatusNotFound, "Not found.")
 }
 
-func printHeader(buf *bytes.Buffer, mod, vers, file string) {
+func printHeader(buf *bytes.Buffer, mod, vers, file string, check *sumCheck) {
 	e := html.EscapeString
 	buf.WriteString("<!DOCTYPE html>\n<head>\n")
 	buf.WriteString("<script src=\"/viewer.js\"></script>\n")
@@ -208,54 +189,180 @@ func printHeader(buf *bytes.Buffer, mod, vers, file string) {
 		f += "/" + elem
 		fmt.Fprintf(buf, `/<a href="/%s@%s%s">%s</a>`, e(mod), e(vers), e(f), e(elem))
 	}
-	fmt.Fprintf(buf, `</b> <small>(<a href="/">about</a>)</small>`)
-	fmt.Fprintf(buf, "\n\n")
+	buf.WriteString("</b> <small>(")
+	if vers != "v" && !strings.Contains(vers, "..") {
+		fmt.Fprintf(buf, `<a href="/%s@%s/@search">search</a>, <a href="/%s@%s/@licenses">licenses</a>, `, e(mod), e(vers), e(mod), e(vers))
+	}
+	fmt.Fprintf(buf, `<a href="/%s/@v">versions</a>, <a href="/">about</a>)</small>`, e(mod))
+	fmt.Fprintf(buf, "\n")
+	switch {
+	case check == nil:
+	case check.Mismatch != "":
+		fmt.Fprintf(buf, "<span class=\"mismatch\">CHECKSUM MISMATCH: zip has %s, checksum database has %s</span>\n", e(check.Hash), e(check.Mismatch))
+	case check.Verified:
+		fmt.Fprintf(buf, "<small>%s (verified)</small>\n", e(check.Hash))
+	case check.Note == notYetChecked:
+		fmt.Fprintf(buf, "<small>checksum %s (<a href=\"/%s@%s/@checksum\">check</a>)</small>\n", notYetChecked, e(mod), e(vers))
+	default:
+		fmt.Fprintf(buf, "<small>%s (%s)</small>\n", e(check.Hash), e(check.Note))
+	}
+	fmt.Fprintf(buf, "\n")
 }
 
-func serveDir(mod, vers, file string, dir []string) []byte {
-	var buf bytes.Buffer
-	e := html.EscapeString
-	printHeader(&buf, mod, vers, file)
-	for _, file := range dir {
-		// Note: file is the full path including mod@vers.
-		fmt.Fprintf(&buf, "<a href=\"/%s\">%s</a>\n", e(file), e(path.Base(file)))
+var nl = []byte("\n")
+
+// serveFile renders the file as L-numbered HTML lines.
+// Go files are highlighted and cross-referenced when they parse.
+// Binary files, and all files in raw mode, are served as is.
+// Files larger than maxInMemoryFile are streamed from the zip.
+func serveFile(ctx context.Context, ix *zipIndex, mod, vers, file string, zf *zipEntry, raw bool) *response {
+	if zf.UncompressedSize > maxInMemoryFile || zf.CompressedSize > maxInMemoryFile {
+		return streamFile(ctx, ix, mod, vers, file, zf, raw)
+	}
+	data, err := ix.readFile(ctx, zf)
+	if err != nil {
+		return errorResponse(http.StatusBadGateway, "i/o error: %v", err)
+	}
+	if raw || !isText(data) {
+		return rawResponse(file, data)
 	}
-	return buf.Bytes()
+
+	var marks []mark
+	if strings.HasSuffix(file, ".go") {
+		marks, _ = goMarks(ctx, ix, mod, vers, file, data)
+	}
+
+	var buf bytes.Buffer
+	check := ix.checkSum(ctx, mod, vers, false)
+	printHeader(&buf, mod, vers, file, check)
+	writeLines(&buf, data, marks)
+	return pageResponse(&buf, check)
 }
 
-var nl = []byte("\n")
+// maxInMemoryFile is the size of the largest file serveFile reads into memory
+// and highlights.
+const maxInMemoryFile = 8 << 20
 
-func serveFile(mod, vers, file string, zf *zip.File) []byte {
-	if zf.UncompressedSize64 > 32<<20 || zf.CompressedSize64 > 32<<20 {
-		return []byte("Too big.")
-	}
-	rc, err := zf.Open()
+// streamFile returns a response that streams the file zf, which is mod@vers/file,
+// from the zip: as is in raw mode or for binary files,
+// and otherwise as L-numbered HTML lines without highlighting.
+func streamFile(ctx context.Context, ix *zipIndex, mod, vers, file string, zf *zipEntry, raw bool) *response {
+	rc, err := ix.openFile(zf)
 	if err != nil {
-		return []byte("i/o error: " + err.Error())
+		return errorResponse(http.StatusBadGateway, "i/o error: %v", err)
+	}
+	br := bufio.NewReaderSize(rc, 64<<10)
+	prefix, err := br.Peek(1024)
+	if err != nil && err != io.EOF {
+		rc.Close()
+		return errorResponse(http.StatusBadGateway, "i/o error: %v", err)
+	}
+	if raw || !isText(prefix) {
+		return &response{
+			Status:      http.StatusOK,
+			ContentType: rawContentType(file, prefix),
+			Raw:         true,
+			Length:      int64(zf.UncompressedSize),
+			Closer:      rc,
+			Stream: func(w io.Writer) error {
+				_, err := io.Copy(w, br)
+				return err
+			},
+		}
 	}
-	defer rc.Close()
-	data, err := io.ReadAll(rc)
-	if err != nil {
-		return []byte("i/o error: " + err.Error())
+
+	var hdr bytes.Buffer
+	check := ix.checkSum(ctx, mod, vers, false)
+	printHeader(&hdr, mod, vers, file, check)
+	return &response{
+		Status:      http.StatusOK,
+		ContentType: htmlType,
+		Volatile:    !check.final(),
+		Closer:      rc,
+		Stream: func(w io.Writer) error {
+			bw := bufio.NewWriterSize(w, 64<<10)
+			bw.Write(hdr.Bytes())
+			err := streamLines(bw, br, zf.UncompressedSize)
+			if err1 := bw.Flush(); err == nil {
+				err = err1
+			}
+			return err
+		},
 	}
-	if !isText(data) {
-		return data
+}
+
+// streamLines writes the lines read from r as writeLines does,
+// without marks. The line count is not known in advance,
+// so the line number width is chosen to fit size lines.
+func streamLines(w *bufio.Writer, r *bufio.Reader, size uint64) error {
+	wid := len(fmt.Sprintf("%d", size+1))
+	wid = (wid+2+7)&^7 - 2
+	for n := 1; ; n++ {
+		line, err := r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// Very long line: read the rest of it.
+			long := append([]byte(nil), line...)
+			for err == bufio.ErrBufferFull {
+				line, err = r.ReadSlice('\n')
+				long = append(long, line...)
+			}
+			line = long
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if len(line) == 0 && err == io.EOF {
+			return nil
+		}
+		fmt.Fprintf(w, "<span id=\"L%d\">%*d  %s\n</span>", n, wid, n, html.EscapeString(string(bytes.TrimSuffix(line, nl))))
+		if err == io.EOF {
+			return nil
+		}
 	}
+}
 
-	var buf bytes.Buffer
+// writeLines writes data to buf as HTML, one L-numbered span per line,
+// applying the marks, which must be sorted and non-overlapping.
+func writeLines(buf *bytes.Buffer, data []byte, marks []mark) {
 	e := html.EscapeString
-	printHeader(&buf, mod, vers, file)
 	n := 1 + bytes.Count(data, nl)
 	wid := len(fmt.Sprintf("%d", n))
 	wid = (wid+2+7)&^7 - 2
 	n = 1
+	off := 0
 	for len(data) > 0 {
 		var line []byte
 		line, data, _ = bytes.Cut(data, nl)
-		fmt.Fprintf(&buf, "<span id=\"L%d\">%*d  %s\n</span>", n, wid, n, e(string(line)))
+		fmt.Fprintf(buf, "<span id=\"L%d\">%*d  ", n, wid, n)
+		start, end := off, off+len(line)
+		pos := start
+		for len(marks) > 0 && marks[0].start < end {
+			m := marks[0]
+			s, t := m.start, m.end
+			if s < pos {
+				s = pos
+			}
+			if t > end {
+				t = end
+			}
+			buf.WriteString(e(string(line[pos-start : s-start])))
+			if m.href != "" {
+				fmt.Fprintf(buf, "<a class=\"%s\" href=\"%s\">%s</a>", m.class, e(m.href), e(string(line[s-start:t-start])))
+			} else {
+				fmt.Fprintf(buf, "<span class=\"%s\">%s</span>", m.class, e(string(line[s-start:t-start])))
+			}
+			pos = t
+			if m.end > end {
+				// Mark continues on next line.
+				break
+			}
+			marks = marks[1:]
+		}
+		buf.WriteString(e(string(line[pos-start:])))
+		buf.WriteString("\n</span>")
+		off = end + 1
 		n++
 	}
-	return buf.Bytes()
 }
 
 // isText reports whether a significant prefix of s looks like correct UTF-8;
@@ -0,0 +1,246 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A proxySpec is a single entry in a GOPROXY list.
+type proxySpec struct {
+	url             string // base URL, "direct", or "off"
+	fallBackOnError bool   // try next entry on any error, not just not-found
+}
+
+// proxies is the list of module proxies to consult, in order.
+// It is derived from $GOPROXY and $GOMODCACHE the same way
+// the go command does, except that "direct" is never used:
+// the viewer does not fetch modules from version control.
+var proxies = proxyList(os.Getenv("GOPROXY"), os.Getenv("GOMODCACHE"))
+
+const defaultProxy = "https://proxy.golang.org"
+
+// proxyList parses a GOPROXY list.
+// Entries are separated by commas or pipes.
+// After a comma, the next entry is tried only if the previous one
+// reported that the file does not exist (HTTP 404 or 410);
+// after a pipe, the next entry is tried after any error.
+// If modcache is not empty, its download cache is consulted first.
+func proxyList(list, modcache string) []proxySpec {
+	if list == "" {
+		list = defaultProxy
+	}
+	var specs []proxySpec
+	if modcache != "" {
+		u := url.URL{Scheme: "file", Path: filepath.ToSlash(filepath.Join(modcache, "cache/download"))}
+		specs = append(specs, proxySpec{url: u.String(), fallBackOnError: true})
+	}
+	for list != "" {
+		var elem string
+		fallBackOnError := false
+		if i := strings.IndexAny(list, ",|"); i >= 0 {
+			elem, fallBackOnError, list = list[:i], list[i] == '|', list[i+1:]
+		} else {
+			elem, list = list, ""
+		}
+		elem = strings.TrimSpace(elem)
+		switch elem {
+		case "":
+			continue
+		case "direct", "off":
+			// Keep as is; handled in proxyLookup.
+		default:
+			// Single-word entries are host names, as in the go command.
+			if !strings.Contains(elem, ":") && !strings.HasPrefix(elem, "/") {
+				elem = "https://" + elem
+			}
+			elem = strings.TrimSuffix(elem, "/")
+		}
+		specs = append(specs, proxySpec{url: elem, fallBackOnError: fallBackOnError})
+	}
+	return specs
+}
+
+var (
+	errDirect = fmt.Errorf("GOPROXY=direct not supported: %w", fs.ErrNotExist)
+	errOff    = errors.New("module lookup disabled by GOPROXY=off")
+)
+
+// proxyLookup calls f with the base URL of each proxy in turn
+// until one succeeds, following the GOPROXY fallback rules.
+// It returns the error from the last proxy tried.
+func proxyLookup(f func(base string) error) error {
+	err := fmt.Errorf("no proxies configured: %w", fs.ErrNotExist)
+	for _, p := range proxies {
+		switch p.url {
+		case "off":
+			return errOff
+		case "direct":
+			err = errDirect
+		default:
+			err = f(p.url)
+			if err == nil {
+				return nil
+			}
+		}
+		if !p.fallBackOnError && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return err
+}
+
+// statProxy returns the URL and size of the named file
+// (an escaped proxy path like "rsc.io/quote/@v/v1.5.2.zip")
+// from the first proxy that has it.
+func statProxy(name string) (url string, size int64, err error) {
+	err = proxyLookup(func(base string) error {
+		u := base + "/" + name
+		n, err := urlSize(u)
+		if err != nil {
+			return err
+		}
+		url, size = u, n
+		return nil
+	})
+	return url, size, err
+}
+
+// fetchProxy returns the content of the named file
+// from the first proxy that has it.
+func fetchProxy(name string) ([]byte, error) {
+	var data []byte
+	err := proxyLookup(func(base string) error {
+		d, err := fetchURL(base + "/" + name)
+		if err != nil {
+			return err
+		}
+		data = d
+		return nil
+	})
+	return data, err
+}
+
+// An httpError is an HTTP response with an unexpected status.
+// It matches fs.ErrNotExist for 404 and 410 responses,
+// which are the ones that allow falling back to the next proxy.
+type httpError struct {
+	url    string
+	status string
+	code   int
+}
+
+func (e *httpError) Error() string {
+	return fmt.Sprintf("%s: HTTP error: %s", e.url, e.status)
+}
+
+func (e *httpError) Is(target error) bool {
+	return target == fs.ErrNotExist && (e.code == 404 || e.code == 410)
+}
+
+// filePath returns the local file name for a file:// URL,
+// or "" if u is not a file:// URL.
+func filePath(u string) string {
+	if !strings.HasPrefix(u, "file://") {
+		return ""
+	}
+	p, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+	return filepath.FromSlash(p.Path)
+}
+
+// urlSize returns the size of the file at u,
+// checking that the server supports range requests for it.
+func urlSize(u string) (int64, error) {
+	if file := filePath(u); file != "" {
+		info, err := os.Stat(file)
+		if err != nil {
+			return 0, err
+		}
+		return info.Size(), nil
+	}
+
+	req, err := http.NewRequest("HEAD", u, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Disable-Module-Fetch", "true")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("%s: HTTP error: %v", u, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return 0, &httpError{u, resp.Status, resp.StatusCode}
+	}
+	if resp.Header.Get("Accept-Ranges") != "bytes" {
+		return 0, fmt.Errorf("%s: bad Accept-Range: %v", u, resp.Header.Get("Accept-Ranges"))
+	}
+	size, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: bad Content-Length: %v", u, err)
+	}
+	return size, nil
+}
+
+// fetchURL returns the content of the file at u.
+func fetchURL(u string) ([]byte, error) {
+	if file := filePath(u); file != "" {
+		return os.ReadFile(file)
+	}
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Disable-Module-Fetch", "true")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s: HTTP error: %v", u, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, &httpError{u, resp.Status, resp.StatusCode}
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: reading body: %v", u, err)
+	}
+	return data, nil
+}
+
+// newReaderAt returns an io.ReaderAt for the file at u, which has the given size.
+func newReaderAt(u string, size int64) io.ReaderAt {
+	if file := filePath(u); file != "" {
+		return &fileReaderAt{file}
+	}
+	return &remoteReaderAt{url: u, size: size}
+}
+
+// A fileReaderAt reads from a local file,
+// opening it for each read so that nothing needs closing.
+type fileReaderAt struct {
+	file string
+}
+
+func (r *fileReaderAt) ReadAt(b []byte, off int64) (int, error) {
+	f, err := os.Open(r.file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return f.ReadAt(b, off)
+}
@@ -0,0 +1,183 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// A remoteReaderAt reads from a file served over HTTP using Range requests.
+// Reads are served from aligned blocks of rangeBlockSize bytes,
+// kept in a cache shared by all readers, so that the many small reads
+// made by zip parsing cost one request for each block.
+// Adjacent missing blocks are fetched with a single request,
+// and a block already being fetched by another goroutine is waited for
+// rather than fetched again.
+type remoteReaderAt struct {
+	url  string
+	size int64
+}
+
+const (
+	rangeBlockSize = 64 << 10
+	rangeReadahead = 1       // blocks fetched past the end of a read that fetches anyway
+	maxBlockedRead = 4 << 20 // larger reads bypass the block cache
+	rangeCacheSize = 64 << 20
+)
+
+// rangeBlocks holds the cached and in-flight blocks, keyed by URL and block number.
+var rangeBlocks = struct {
+	sync.Mutex
+	cache    *memoryCache
+	inflight map[string]*blockFetch
+}{
+	cache:    newMemoryCache(rangeCacheSize),
+	inflight: make(map[string]*blockFetch),
+}
+
+// A blockFetch is a block being fetched, or already available.
+type blockFetch struct {
+	done chan struct{} // closed when data and err are set
+	data []byte
+	err  error
+}
+
+var closedChan = make(chan struct{})
+
+func init() {
+	close(closedChan)
+}
+
+func (r *remoteReaderAt) blockKey(i int64) string {
+	return fmt.Sprintf("%s#%d", r.url, i)
+}
+
+func (r *remoteReaderAt) ReadAt(b []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	n := r.size - off
+	if n > int64(len(b)) {
+		n = int64(len(b))
+	}
+	b = b[:n]
+	if n > maxBlockedRead {
+		data, err := r.fetch(off, off+n)
+		if err != nil {
+			return 0, err
+		}
+		return copy(b, data), nil
+	}
+
+	// Find or start fetches for the blocks covering [off, off+n),
+	// grouping adjacent missing blocks into runs.
+	ctx := context.Background()
+	first, last := off/rangeBlockSize, (off+n-1)/rangeBlockSize
+	blocks := make([]*blockFetch, last-first+1)
+	type run struct {
+		start, end int64 // block numbers
+		fetches    []*blockFetch
+	}
+	var runs []*run
+	rangeBlocks.Lock()
+	for i := first; i*rangeBlockSize < r.size && i <= last+rangeReadahead; i++ {
+		key := r.blockKey(i)
+		var f *blockFetch
+		if data, err := rangeBlocks.cache.Get(ctx, key); err == nil {
+			f = &blockFetch{done: closedChan, data: data}
+		} else if f = rangeBlocks.inflight[key]; f == nil {
+			if i > last && (len(runs) == 0 || runs[len(runs)-1].end != i) {
+				// Read ahead only to extend a request being made anyway.
+				break
+			}
+			f = &blockFetch{done: make(chan struct{})}
+			rangeBlocks.inflight[key] = f
+			if len(runs) > 0 && runs[len(runs)-1].end == i {
+				runs[len(runs)-1].end++
+			} else {
+				runs = append(runs, &run{start: i, end: i + 1})
+			}
+			runs[len(runs)-1].fetches = append(runs[len(runs)-1].fetches, f)
+		} else if i > last {
+			break
+		}
+		if i <= last {
+			blocks[i-first] = f
+		}
+	}
+	rangeBlocks.Unlock()
+
+	for _, rn := range runs {
+		go func(rn *run) {
+			end := rn.end * rangeBlockSize
+			if end > r.size {
+				end = r.size
+			}
+			data, err := r.fetch(rn.start*rangeBlockSize, end)
+			rangeBlocks.Lock()
+			for j, f := range rn.fetches {
+				if err != nil {
+					f.err = err
+				} else {
+					lo := int64(j) * rangeBlockSize
+					hi := lo + rangeBlockSize
+					if hi > int64(len(data)) {
+						hi = int64(len(data))
+					}
+					f.data = data[lo:hi:hi]
+					rangeBlocks.cache.Set(ctx, r.blockKey(rn.start+int64(j)), f.data, dataTTL)
+				}
+				delete(rangeBlocks.inflight, r.blockKey(rn.start+int64(j)))
+				close(f.done)
+			}
+			rangeBlocks.Unlock()
+		}(rn)
+	}
+
+	total := 0
+	for i, f := range blocks {
+		<-f.done
+		if f.err != nil {
+			return 0, f.err
+		}
+		start := off + int64(total) - (first+int64(i))*rangeBlockSize
+		total += copy(b[total:], f.data[start:])
+	}
+	return total, nil
+}
+
+// fetch returns the bytes [start, end) of the file, using a single Range request.
+func (r *remoteReaderAt) fetch(start, end int64) ([]byte, error) {
+	n := end - start
+	req, err := http.NewRequest("GET", r.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Disable-Module-Fetch", "true")
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end-1))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 206 {
+		return nil, fmt.Errorf("%s: %s", r.url, resp.Status)
+	}
+	if resp.Header.Get("Content-Length") != fmt.Sprint(n) {
+		return nil, fmt.Errorf("%s: bad Content-Length: %v != %v", r.url, resp.Header.Get("Content-Length"), n)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: reading body: %v", r.url, err)
+	}
+	if int64(len(data)) != n {
+		return nil, fmt.Errorf("%s: unexpected data length %v != %v", r.url, len(data), n)
+	}
+	return data, nil
+}
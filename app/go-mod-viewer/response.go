@@ -0,0 +1,178 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// A response is the result of serving a request, ready to be cached and written.
+type response struct {
+	Status      int
+	ContentType string
+	ETag        string // quoted, or empty
+	Immutable   bool   // content cannot change, because it comes from fixed module versions
+	Volatile    bool   // page shows checksum, network or load status, which can change
+	Raw         bool   // body is module file content, not a page rendered by the viewer
+	Body        []byte
+
+	// Stream, if non-nil, writes the body in place of Body.
+	// Streamed responses are not cached, because func fields are not gob-encoded.
+	Stream func(w io.Writer) error
+	Length int64 // length of the streamed body, or 0 if unknown
+
+	// Closer, if non-nil, releases what Stream reads from.
+	// It is closed once the response is written, whether or not Stream is called.
+	Closer io.Closer
+}
+
+const htmlType = "text/html; charset=utf-8"
+
+// htmlResponse returns a 200 response holding the HTML page in buf.
+func htmlResponse(buf *bytes.Buffer) *response {
+	return &response{Status: http.StatusOK, ContentType: htmlType, Body: buf.Bytes()}
+}
+
+// pageResponse returns a 200 response holding the HTML page in buf,
+// whose header shows the checksum verdict check.
+// The page is volatile until the verdict is final.
+func pageResponse(buf *bytes.Buffer, check *sumCheck) *response {
+	r := htmlResponse(buf)
+	r.Volatile = !check.final()
+	return r
+}
+
+// errorResponse returns a plain text response with the given status and message.
+func errorResponse(status int, format string, args ...any) *response {
+	return &response{
+		Status:      status,
+		ContentType: "text/plain; charset=utf-8",
+		Body:        []byte(fmt.Sprintf(format, args...) + "\n"),
+	}
+}
+
+// proxyErrorResponse returns a response for an error fetching from the module proxy:
+// a 404 if the module or file does not exist, and a 502 otherwise.
+func proxyErrorResponse(err error) *response {
+	if errors.Is(err, fs.ErrNotExist) {
+		return errorResponse(http.StatusNotFound, "%v", err)
+	}
+	return errorResponse(http.StatusBadGateway, "%v", err)
+}
+
+// rawResponse returns a response serving data, the content of the named module file, as is.
+// The content type is derived from the file name or, failing that, the content,
+// but active content such as HTML is served as plain text,
+// and the response forbids scripts in any case.
+func rawResponse(name string, data []byte) *response {
+	return &response{Status: http.StatusOK, ContentType: rawContentType(name, data), Raw: true, Body: data}
+}
+
+// rawContentType returns the content type for serving the named module file,
+// whose content begins with data.
+func rawContentType(name string, data []byte) string {
+	ctype := mime.TypeByExtension(path.Ext(name))
+	if ctype == "" {
+		ctype = http.DetectContentType(data)
+	}
+	mtype, _, _ := strings.Cut(ctype, ";")
+	switch {
+	case strings.HasPrefix(mtype, "text/"),
+		strings.Contains(mtype, "javascript"),
+		strings.Contains(mtype, "xml"),
+		strings.Contains(mtype, "json"):
+		ctype = "text/plain; charset=utf-8"
+	}
+	if !isText(data) && ctype == "text/plain; charset=utf-8" {
+		ctype = "application/octet-stream"
+	}
+	return ctype
+}
+
+// setVersioned marks r, which was computed from the module zips with the given sums
+// for the request urlPath?rawQuery, as immutable and gives it an ETag,
+// provided it is a successful response that depends only on the zips.
+func (r *response) setVersioned(urlPath, rawQuery string, sums ...[]byte) {
+	if r.Status != http.StatusOK || r.Volatile {
+		return
+	}
+	h := sha256.New()
+	for _, s := range sums {
+		binary.Write(h, binary.BigEndian, uint32(len(s)))
+		h.Write(s)
+	}
+	h.Write([]byte(urlPath))
+	if !r.Raw {
+		// Rendered pages also depend on the query and the viewer.
+		fmt.Fprintf(h, "?%s#%s", rawQuery, viewVersion)
+	}
+	r.ETag = fmt.Sprintf(`"%x"`, h.Sum(nil)[:16])
+	r.Immutable = true
+}
+
+// write writes r to w in reply to req.
+func (r *response) write(w http.ResponseWriter, req *http.Request) {
+	if r.Closer != nil {
+		defer r.Closer.Close()
+	}
+	h := w.Header()
+	h.Set("Content-Type", r.ContentType)
+	switch {
+	case r.Immutable:
+		h.Set("Cache-Control", "public, max-age=31536000, immutable")
+	case r.Status == http.StatusOK && !r.Volatile:
+		h.Set("Cache-Control", "public, max-age=300")
+	default:
+		h.Set("Cache-Control", "public, max-age=60")
+	}
+	if r.Raw {
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	}
+	if r.ETag != "" {
+		h.Set("ETag", r.ETag)
+		if etagMatch(req.Header.Get("If-None-Match"), r.ETag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	if r.Stream != nil && r.Length > 0 {
+		h.Set("Content-Length", fmt.Sprint(r.Length))
+	}
+	w.WriteHeader(r.Status)
+	if req.Method == "HEAD" {
+		return
+	}
+	if r.Stream != nil {
+		if err := r.Stream(w); err != nil {
+			// Too late to report an error status; the client sees a truncated body.
+			log.Printf("%s: %v", req.URL.Path, err)
+		}
+		return
+	}
+	w.Write(r.Body)
+}
+
+// etagMatch reports whether the If-None-Match header value list matches etag.
+func etagMatch(list, etag string) bool {
+	for _, x := range strings.Split(list, ",") {
+		x = strings.TrimSpace(x)
+		if x == "*" || strings.TrimPrefix(x, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
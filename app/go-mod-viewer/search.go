@@ -0,0 +1,430 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A trigramIndex maps each three-byte sequence to the text files in a module zip containing it.
+type trigramIndex struct {
+	files []*zipEntry         // indexed text files, sorted by name
+	post  map[uint32][]uint32 // trigram -> indexes into files, increasing
+}
+
+// Limits on search indexing and results.
+const (
+	maxSearchFile    = 1 << 20 // largest file to index
+	maxTrigramIndex  = 20      // number of trigram indexes kept in memory
+	defaultMaxResult = 200
+	maxMaxResult     = 1000
+	maxFileResults   = 20 // matching lines shown per file
+)
+
+// trigramCache holds the trigram indexes kept in memory
+// and those being loaded, keyed by zip URL.
+var trigramCache = struct {
+	sync.Mutex
+	m        map[string]*trigramIndex
+	inflight map[string]*trigramLoad
+}{
+	m:        make(map[string]*trigramIndex),
+	inflight: make(map[string]*trigramLoad),
+}
+
+// A trigramLoad is a trigram index being loaded.
+type trigramLoad struct {
+	done chan struct{} // closed when tx and err are set
+	tx   *trigramIndex
+	err  error
+}
+
+// loadTrigramIndex returns the trigram index for ix,
+// consulting the in-memory cache and then viewerCache
+// before building it by downloading the whole zip.
+// An index already being loaded by another goroutine is waited for
+// rather than loaded again.
+func loadTrigramIndex(ctx context.Context, ix *zipIndex) (*trigramIndex, error) {
+	trigramCache.Lock()
+	if tx := trigramCache.m[ix.URL]; tx != nil {
+		trigramCache.Unlock()
+		return tx, nil
+	}
+	l := trigramCache.inflight[ix.URL]
+	if l == nil {
+		l = &trigramLoad{done: make(chan struct{})}
+		trigramCache.inflight[ix.URL] = l
+		trigramCache.Unlock()
+
+		l.tx, l.err = readTrigramIndex(ctx, ix)
+
+		trigramCache.Lock()
+		delete(trigramCache.inflight, ix.URL)
+		if l.err == nil {
+			if len(trigramCache.m) >= maxTrigramIndex {
+				for k := range trigramCache.m {
+					delete(trigramCache.m, k)
+					break
+				}
+			}
+			trigramCache.m[ix.URL] = l.tx
+		}
+		close(l.done)
+	}
+	trigramCache.Unlock()
+
+	select {
+	case <-l.done:
+		return l.tx, l.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// trigramIndexData is the form of a trigramIndex stored in viewerCache.
+type trigramIndexData struct {
+	Files []string // full names of the indexed files
+	Post  map[uint32][]uint32
+}
+
+// readTrigramIndex returns the trigram index for ix from viewerCache,
+// or else builds it and stores it there.
+func readTrigramIndex(ctx context.Context, ix *zipIndex) (*trigramIndex, error) {
+	key := ix.cacheKey("trigram")
+	if data, err := viewerCache.Get(ctx, key); err == nil {
+		var td trigramIndexData
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&td); err != nil {
+			log.Printf("%s: bad cached trigram index: %v", ix.URL, err)
+		} else if tx := td.index(ix); tx != nil {
+			return tx, nil
+		}
+	}
+
+	tx, err := buildTrigramIndex(ix)
+	if err != nil {
+		return nil, err
+	}
+	td := trigramIndexData{Post: tx.post}
+	for _, f := range tx.files {
+		td.Files = append(td.Files, f.Name)
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&td); err != nil {
+		log.Printf("%s: encoding trigram index: %v", ix.URL, err)
+	} else if err := viewerCache.Set(ctx, key, buf.Bytes(), indexTTL); err != nil {
+		log.Print(err)
+	}
+	return tx, nil
+}
+
+// index returns the trigram index for ix stored in td,
+// or nil if td does not match ix.
+func (td *trigramIndexData) index(ix *zipIndex) *trigramIndex {
+	tx := &trigramIndex{files: make([]*zipEntry, len(td.Files)), post: td.Post}
+	for i, name := range td.Files {
+		if tx.files[i] = ix.lookup(name); tx.files[i] == nil {
+			return nil
+		}
+	}
+	if tx.post == nil {
+		tx.post = make(map[uint32][]uint32)
+	}
+	return tx
+}
+
+// buildTrigramIndex builds the trigram index for ix by downloading the whole zip.
+func buildTrigramIndex(ix *zipIndex) (*trigramIndex, error) {
+	zr, err := ix.openZip()
+	if err != nil {
+		return nil, err
+	}
+
+	tx := &trigramIndex{post: make(map[uint32][]uint32)}
+	var tris []uint32
+	for _, zf := range zr.File {
+		f := ix.lookup(zf.Name)
+		if f == nil || f.UncompressedSize > maxSearchFile {
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			continue
+		}
+		text, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil || !isText(text) {
+			continue
+		}
+		tx.files = append(tx.files, f)
+		tris = tris[:0]
+		for i := 0; i+3 <= len(text); i++ {
+			tris = append(tris, uint32(text[i])<<16|uint32(text[i+1])<<8|uint32(text[i+2]))
+		}
+		sort.Slice(tris, func(i, j int) bool { return tris[i] < tris[j] })
+		id := uint32(len(tx.files) - 1)
+		for i, t := range tris {
+			if i == 0 || t != tris[i-1] {
+				tx.post[t] = append(tx.post[t], id)
+			}
+		}
+	}
+	// The zip is in file order, which is usually but not necessarily name order.
+	// Renumber if needed so that results come out sorted by name.
+	if !sort.SliceIsSorted(tx.files, func(i, j int) bool { return tx.files[i].Name < tx.files[j].Name }) {
+		order := make([]uint32, len(tx.files))
+		sorted := append([]*zipEntry(nil), tx.files...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+		pos := make(map[*zipEntry]uint32)
+		for i, f := range sorted {
+			pos[f] = uint32(i)
+		}
+		for i, f := range tx.files {
+			order[i] = pos[f]
+		}
+		for t, list := range tx.post {
+			for i, id := range list {
+				list[i] = order[id]
+			}
+			sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+			tx.post[t] = list
+		}
+		tx.files = sorted
+	}
+	return tx, nil
+}
+
+// candidates returns the files that may contain all the literal strings in lits.
+// Strings shorter than three bytes do not narrow the search.
+func (tx *trigramIndex) candidates(lits []string) []*zipEntry {
+	var ids []uint32
+	all := true
+	for _, lit := range lits {
+		for i := 0; i+3 <= len(lit); i++ {
+			list := tx.post[uint32(lit[i])<<16|uint32(lit[i+1])<<8|uint32(lit[i+2])]
+			if all {
+				ids = append([]uint32(nil), list...)
+				all = false
+				continue
+			}
+			ids = intersect(ids, list)
+		}
+	}
+	if all {
+		return tx.files
+	}
+	files := make([]*zipEntry, len(ids))
+	for i, id := range ids {
+		files[i] = tx.files[id]
+	}
+	return files
+}
+
+// intersect returns the elements in both a and b, which must be sorted.
+// It overwrites a.
+func intersect(a, b []uint32) []uint32 {
+	out := a[:0]
+	for len(a) > 0 && len(b) > 0 {
+		switch {
+		case a[0] < b[0]:
+			a = a[1:]
+		case a[0] > b[0]:
+			b = b[1:]
+		default:
+			out = append(out, a[0])
+			a, b = a[1:], b[1:]
+		}
+	}
+	return out
+}
+
+// requiredLiterals returns strings that must appear in any text matched by re.
+// It is conservative: returning no strings is always correct.
+func requiredLiterals(re *syntax.Regexp) []string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase == 0 {
+			return []string{string(re.Rune)}
+		}
+	case syntax.OpCapture, syntax.OpPlus:
+		return requiredLiterals(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min > 0 {
+			return requiredLiterals(re.Sub[0])
+		}
+	case syntax.OpConcat:
+		var lits []string
+		for _, sub := range re.Sub {
+			lits = append(lits, requiredLiterals(sub)...)
+		}
+		return lits
+	}
+	return nil
+}
+
+// A searchResult is a file with matching lines.
+type searchResult struct {
+	file  *zipEntry
+	lines []searchLine
+	more  int // number of additional matching lines not recorded
+}
+
+type searchLine struct {
+	n          int // line number
+	text       string
+	start, end int // match within text
+}
+
+// serveSearch serves the search page for mod@vers,
+// with results for the query in the q parameter, if any.
+// The query is a literal string unless re=1 is set.
+func serveSearch(ctx context.Context, ix *zipIndex, mod, vers string, query url.Values) *response {
+	var buf bytes.Buffer
+	e := html.EscapeString
+	check := ix.checkSum(ctx, mod, vers, false)
+	printHeader(&buf, mod, vers, "@search", check)
+
+	q := query.Get("q")
+	isRE := query.Get("re") == "1"
+	checked := ""
+	if isRE {
+		checked = " checked"
+	}
+	fmt.Fprintf(&buf, "</pre><form action=\"/%s@%s/@search\">", e(mod), e(vers))
+	fmt.Fprintf(&buf, "<input name=\"q\" size=\"60\" value=\"%s\" autofocus> ", e(q))
+	fmt.Fprintf(&buf, "<label><input type=\"checkbox\" name=\"re\" value=\"1\"%s> regexp</label> ", checked)
+	buf.WriteString("<input type=\"submit\" value=\"Search\"></form><pre>\n")
+	if q == "" {
+		return pageResponse(&buf, check)
+	}
+
+	max := defaultMaxResult
+	if n, err := strconv.Atoi(query.Get("max")); err == nil && n > 0 {
+		max = n
+		if max > maxMaxResult {
+			max = maxMaxResult
+		}
+	}
+
+	pattern := q
+	if !isRE {
+		pattern = regexp.QuoteMeta(q)
+	}
+	syn, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		fmt.Fprintf(&buf, "%s\n", e(err.Error()))
+		resp := pageResponse(&buf, check)
+		resp.Status = http.StatusBadRequest
+		return resp
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		fmt.Fprintf(&buf, "%s\n", e(err.Error()))
+		resp := pageResponse(&buf, check)
+		resp.Status = http.StatusBadRequest
+		return resp
+	}
+	tx, err := loadTrigramIndex(ctx, ix)
+	if err != nil {
+		fmt.Fprintf(&buf, "%s\n", e(err.Error()))
+		resp := pageResponse(&buf, check)
+		resp.Status = http.StatusServiceUnavailable
+		return resp
+	}
+	files := tx.candidates(requiredLiterals(syn.Simplify()))
+
+	// Read and search candidate files in parallel, in batches,
+	// stopping once enough results have been found.
+	var results []*searchResult
+	total := 0
+	const batch = 16
+	for i := 0; i < len(files) && total < max; i += batch {
+		chunk := files[i:]
+		if len(chunk) > batch {
+			chunk = chunk[:batch]
+		}
+		rs := make([]*searchResult, len(chunk))
+		var wg sync.WaitGroup
+		for j, f := range chunk {
+			wg.Add(1)
+			go func(j int, f *zipEntry) {
+				defer wg.Done()
+				data, err := ix.readFile(ctx, f)
+				if err == nil {
+					rs[j] = searchFile(re, f, data)
+				}
+			}(j, f)
+		}
+		wg.Wait()
+		for _, r := range rs {
+			if r == nil || len(r.lines) == 0 {
+				continue
+			}
+			if total+len(r.lines) > max {
+				r.more += total + len(r.lines) - max
+				r.lines = r.lines[:max-total]
+			}
+			total += len(r.lines)
+			results = append(results, r)
+			if total >= max {
+				break
+			}
+		}
+	}
+
+	if len(results) == 0 {
+		buf.WriteString("No matches.\n")
+		return pageResponse(&buf, check)
+	}
+	prefix := mod + "@" + vers + "/"
+	for _, r := range results {
+		name := strings.TrimPrefix(r.file.Name, prefix)
+		fmt.Fprintf(&buf, "<b><a href=\"/%s\">%s</a></b>\n", e(r.file.Name), e(name))
+		for _, l := range r.lines {
+			fmt.Fprintf(&buf, "<a href=\"/%s#L%d\">%6d</a>  %s<b>%s</b>%s\n", e(r.file.Name), l.n, l.n,
+				e(l.text[:l.start]), e(l.text[l.start:l.end]), e(l.text[l.end:]))
+		}
+		if r.more > 0 {
+			fmt.Fprintf(&buf, "        ... %d more\n", r.more)
+		}
+		buf.WriteString("\n")
+	}
+	if total >= max {
+		fmt.Fprintf(&buf, "Results limited to %d lines.\n", max)
+	}
+	return pageResponse(&buf, check)
+}
+
+// searchFile returns the lines in data matching re.
+func searchFile(re *regexp.Regexp, f *zipEntry, data []byte) *searchResult {
+	r := &searchResult{file: f}
+	n := 1
+	for len(data) > 0 {
+		var line []byte
+		line, data, _ = bytes.Cut(data, nl)
+		if m := re.FindIndex(line); m != nil {
+			if len(r.lines) < maxFileResults {
+				r.lines = append(r.lines, searchLine{n, string(line), m[0], m[1]})
+			} else {
+				r.more++
+			}
+		}
+		n++
+	}
+	return r
+}
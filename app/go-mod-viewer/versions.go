@@ -0,0 +1,224 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// maxInfo is the maximum number of versions for which serveVersions fetches .info files.
+const maxInfo = 200
+
+// serveVersions serves the list of versions of mod known to the proxy,
+// newest first, with their commit times.
+func serveVersions(mod string) *response {
+	epath, err := module.EscapePath(mod)
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, "Invalid module path: %s", mod)
+	}
+	data, err := fetchProxy(epath + "/@v/list")
+	if err != nil {
+		return proxyErrorResponse(err)
+	}
+	var list []string
+	for _, v := range strings.Fields(string(data)) {
+		if semver.IsValid(v) {
+			list = append(list, v)
+		}
+	}
+	semver.Sort(list)
+	for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+		list[i], list[j] = list[j], list[i]
+	}
+
+	times := make([]time.Time, len(list))
+	var wg sync.WaitGroup
+	sema := make(chan bool, 10)
+	for i, v := range list {
+		if i >= maxInfo {
+			break
+		}
+		evers, err := module.EscapeVersion(v)
+		if err != nil {
+			continue
+		}
+		wg.Add(1)
+		sema <- true
+		go func(i int, evers string) {
+			defer func() {
+				<-sema
+				wg.Done()
+			}()
+			data, err := fetchProxy(epath + "/@v/" + evers + ".info")
+			if err != nil {
+				return
+			}
+			var info struct{ Time time.Time }
+			if json.Unmarshal(data, &info) == nil {
+				times[i] = info.Time
+			}
+		}(i, evers)
+	}
+	wg.Wait()
+
+	var buf bytes.Buffer
+	e := html.EscapeString
+	printHeader(&buf, mod, "v", "", nil)
+	if len(list) == 0 {
+		buf.WriteString("No tagged versions.\n")
+	}
+	for i, v := range list {
+		t := "                    "
+		if !times[i].IsZero() {
+			t = times[i].UTC().Format("2006-01-02 15:04:05Z")
+		}
+		fmt.Fprintf(&buf, "%s  <a href=\"/%s@%s\">%s</a>", t, e(mod), e(v), e(v))
+		if i+1 < len(list) {
+			fmt.Fprintf(&buf, " <small>(<a href=\"/%s@%s..%s\">diff %s</a>, <a href=\"/%s@%s..%s/@api\">api</a>)</small>",
+				e(mod), e(list[i+1]), e(v), e(list[i+1]), e(mod), e(list[i+1]), e(v))
+		}
+		buf.WriteString("\n")
+	}
+	return htmlResponse(&buf)
+}
+
+// maxInlineDiffs is the maximum number of per-file diffs shown
+// on a directory diff page; the rest are linked.
+const maxInlineDiffs = 50
+
+// serveDiff serves the differences between mod@v1 and mod@v2
+// in file, which may name a single file or a directory ("" for the whole module).
+func serveDiff(ctx context.Context, mod, v1, v2, file string, query url.Values) *response {
+	epath, err := module.EscapePath(mod)
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, "Invalid module path: %s", mod)
+	}
+	var ix [2]*zipIndex
+	for i, v := range []string{v1, v2} {
+		evers, err := module.EscapeVersion(v)
+		if err != nil {
+			return errorResponse(http.StatusBadRequest, "Invalid module version.")
+		}
+		ix[i], err = loadIndex(ctx, epath+"/@v/"+evers+".zip")
+		if err != nil {
+			return proxyErrorResponse(err)
+		}
+	}
+	var resp *response
+	if file == "@api" || strings.HasSuffix(file, "/@api") {
+		resp = serveAPIDiff(ctx, ix, mod, v1, v2, strings.TrimSuffix(strings.TrimSuffix(file, "@api"), "/"))
+	} else {
+		resp = diffFiles(ctx, ix, mod, v1, v2, file)
+	}
+	resp.setVersioned("/"+mod+"@"+v1+".."+v2+"/"+file, query.Encode(), ix[0].sum(ctx), ix[1].sum(ctx))
+	return resp
+}
+
+// diffFiles serves the differences in file between mod@v1 and mod@v2,
+// whose zip indexes are ix[0] and ix[1].
+func diffFiles(ctx context.Context, ix [2]*zipIndex, mod, v1, v2, file string) *response {
+
+	// Collect files under file in each version, keyed by path within the module.
+	var files [2]map[string]*zipEntry
+	for i, v := range []string{v1, v2} {
+		files[i] = make(map[string]*zipEntry)
+		prefix := mod + "@" + v + "/"
+		for j := range ix[i].Files {
+			zf := &ix[i].Files[j]
+			name := strings.TrimPrefix(zf.Name, prefix)
+			if file == "" || name == file || strings.HasPrefix(name, file+"/") {
+				files[i][name] = zf
+			}
+		}
+	}
+	var names []string
+	for name := range files[0] {
+		names = append(names, name)
+	}
+	for name := range files[1] {
+		if files[0][name] == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	e := html.EscapeString
+	printHeader(&buf, mod, v1+".."+v2, file, nil)
+	if len(names) == 0 {
+		return errorResponse(http.StatusNotFound, "Not found.")
+	}
+	var changed []string
+	same := 0
+	volatile := false
+	for _, name := range names {
+		f1, f2 := files[0][name], files[1][name]
+		switch {
+		case f1 == nil:
+			fmt.Fprintf(&buf, "<span class=\"add\">added   </span> <a href=\"/%s@%s/%s\">%s</a>\n", e(mod), e(v2), e(name), e(name))
+		case f2 == nil:
+			fmt.Fprintf(&buf, "<span class=\"del\">removed </span> <a href=\"/%s@%s/%s\">%s</a>\n", e(mod), e(v1), e(name), e(name))
+		case f1.CRC32 != f2.CRC32 || f1.UncompressedSize != f2.UncompressedSize:
+			fmt.Fprintf(&buf, "changed  <a href=\"/%s@%s..%s/%s\">%s</a>\n", e(mod), e(v1), e(v2), e(name), e(name))
+			changed = append(changed, name)
+		default:
+			same++
+		}
+	}
+	if same == len(names) {
+		buf.WriteString("\nNo changes.\n")
+	}
+
+	for i, name := range changed {
+		if i >= maxInlineDiffs {
+			fmt.Fprintf(&buf, "\n%d more changed files not shown.\n", len(changed)-i)
+			break
+		}
+		f1, f2 := files[0][name], files[1][name]
+		aURL := "/" + mod + "@" + v1 + "/" + name
+		bURL := "/" + mod + "@" + v2 + "/" + name
+		fmt.Fprintf(&buf, "\n<b>diff <a href=\"%s\">%s@%s/%s</a> <a href=\"%s\">%s@%s/%s</a></b>\n",
+			e(aURL), e(mod), e(v1), e(name), e(bURL), e(mod), e(v2), e(name))
+		if f1.UncompressedSize > 1<<20 || f2.UncompressedSize > 1<<20 {
+			buf.WriteString("Files too large to diff.\n")
+			continue
+		}
+		a, err := ix[0].readFile(ctx, f1)
+		if err != nil {
+			fmt.Fprintf(&buf, "%s\n", e(err.Error()))
+			volatile = true
+			continue
+		}
+		b, err := ix[1].readFile(ctx, f2)
+		if err != nil {
+			fmt.Fprintf(&buf, "%s\n", e(err.Error()))
+			volatile = true
+			continue
+		}
+		if !isText(a) || !isText(b) {
+			buf.WriteString("Binary files differ.\n")
+			continue
+		}
+		if !writeUnified(&buf, splitLines(a), splitLines(b), aURL, bURL) {
+			buf.WriteString("Diff too large.\n")
+		}
+	}
+	resp := htmlResponse(&buf)
+	resp.Volatile = volatile // i/o errors may be transient
+	return resp
+}
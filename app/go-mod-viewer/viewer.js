@@ -1,4 +1,8 @@
 function highlight() {
+	var old = document.querySelectorAll(".sel");
+	for(var i = 0; i < old.length; i++) {
+		old[i].classList.remove("sel");
+	}
 	if(window.location.hash) {
 		var span = document.getElementById(window.location.hash.substr(1));
 		if(span) {
@@ -6,3 +10,5 @@ function highlight() {
 		}
 	}
 }
+
+window.addEventListener("hashchange", highlight);
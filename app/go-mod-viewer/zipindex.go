@@ -0,0 +1,420 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A zipIndex is the parsed central directory of a module zip file.
+// It records enough about each file to read the file's data
+// with a single range request, without consulting the zip again.
+type zipIndex struct {
+	URL   string // where the zip was found
+	Size  int64
+	Files []zipEntry // in central directory order
+
+	treeOnce sync.Once
+	files    map[string]*zipEntry // by full name; built on first use
+	dirs     map[string]*dirInfo  // by full name; built on first use
+
+	hashMu sync.Mutex
+	hash   string // h1: hash of the zip; computed on first use
+
+	sumMu    sync.Mutex
+	check    *sumCheck     // latest result of checking the zip against the checksum database
+	checked  time.Time     // when check was computed
+	checking chan struct{} // closed when the check in progress finishes; nil if none
+}
+
+// A zipEntry describes a single file in a module zip.
+type zipEntry struct {
+	Name             string
+	Method           uint16
+	CRC32            uint32
+	CompressedSize   uint64
+	UncompressedSize uint64
+	Offset           int64 // offset of local file header
+	End              int64 // offset just past the file data (next header or central directory)
+}
+
+// maxIndexes is the maximum number of indexes kept in memory.
+const maxIndexes = 1000
+
+var indexCache struct {
+	sync.Mutex
+	m map[string]*zipIndex
+}
+
+// loadIndex returns the index for the named zip file
+// (an escaped proxy path like "rsc.io/quote/@v/v1.5.2.zip"),
+// consulting the in-memory cache and then viewerCache
+// before finding the zip on a proxy and reading its central directory.
+func loadIndex(ctx context.Context, name string) (*zipIndex, error) {
+	indexCache.Lock()
+	ix := indexCache.m[name]
+	indexCache.Unlock()
+	if ix != nil {
+		return ix, nil
+	}
+
+	sum := sha256.Sum256([]byte(name))
+	key := fmt.Sprintf("zipindex.%x", sum[:])
+	if data, err := viewerCache.Get(ctx, key); err == nil {
+		ix = new(zipIndex)
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(ix); err != nil {
+			log.Printf("%s: bad cached index: %v", name, err)
+			ix = nil
+		}
+	}
+	if ix == nil {
+		url, size, err := statProxy(name)
+		if err != nil {
+			return nil, err
+		}
+		ix, err = readIndex(newReaderAt(url, size), size)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", url, err)
+		}
+		ix.URL = url
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(ix); err != nil {
+			log.Printf("%s: encoding index: %v", url, err)
+		} else if err := viewerCache.Set(ctx, key, buf.Bytes(), indexTTL); err != nil {
+			log.Print(err)
+		}
+	}
+
+	indexCache.Lock()
+	if indexCache.m == nil {
+		indexCache.m = make(map[string]*zipIndex)
+	}
+	if len(indexCache.m) >= maxIndexes {
+		for k := range indexCache.m {
+			delete(indexCache.m, k)
+			break
+		}
+	}
+	indexCache.m[name] = ix
+	indexCache.Unlock()
+	return ix, nil
+}
+
+// sum identifies the zip's content for use in ETags.
+// It is the zip's h1: hash, as recorded in go.sum files, if that is known,
+// and otherwise a hash of the zip's file list, including each file's CRC-32.
+func (ix *zipIndex) sum(ctx context.Context) []byte {
+	if h := ix.cachedHash(ctx); h != "" {
+		return []byte(h)
+	}
+	h := sha256.New()
+	for _, f := range ix.Files {
+		fmt.Fprintf(h, "%s %08x %d\n", f.Name, f.CRC32, f.UncompressedSize)
+	}
+	return h.Sum(nil)
+}
+
+// maxCachedData is the largest raw file data stored in viewerCache.
+// It is just under the memcache item size limit.
+const maxCachedData = 1000 << 10
+
+// Zip file format constants.
+const (
+	fileHeaderSignature      = 0x04034b50
+	directoryHeaderSignature = 0x02014b50
+	directoryEndSignature    = 0x06054b50
+	directory64LocSignature  = 0x07064b50
+	directory64EndSignature  = 0x06064b50
+	fileHeaderLen            = 30
+	directoryHeaderLen       = 46
+	directoryEndLen          = 22
+	directory64LocLen        = 20
+	directory64EndLen        = 56
+	zip64ExtraID             = 0x0001
+)
+
+var errFormat = errors.New("zip: not a valid zip file")
+
+// readIndex reads the central directory of the zip file r of the given size.
+// It reads the end of the file once and, if the central directory
+// does not fit in that tail, reads the directory once more.
+func readIndex(r io.ReaderAt, size int64) (*zipIndex, error) {
+	// The end of central directory record is at the end of the file,
+	// followed by a comment of at most 64 kB.
+	// Module zips have no comment, so the first read usually
+	// also contains the entire central directory.
+	tailLen := int64(64 << 10)
+	if tailLen > size {
+		tailLen = size
+	}
+	tailOff := size - tailLen
+	tail := make([]byte, tailLen)
+	if _, err := r.ReadAt(tail, tailOff); err != nil && err != io.EOF {
+		return nil, err
+	}
+	end := -1
+	for i := len(tail) - directoryEndLen; i >= 0; i-- {
+		if binary.LittleEndian.Uint32(tail[i:]) == directoryEndSignature {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return nil, errFormat
+	}
+	b := tail[end:]
+	count := uint64(binary.LittleEndian.Uint16(b[10:]))
+	dirSize := uint64(binary.LittleEndian.Uint32(b[12:]))
+	dirOff := uint64(binary.LittleEndian.Uint32(b[16:]))
+	if count == 0xFFFF || dirSize == 0xFFFFFFFF || dirOff == 0xFFFFFFFF {
+		// Zip64: the real values are in the zip64 end of central directory record,
+		// found by way of the locator just before the end record.
+		if end < directory64LocLen {
+			return nil, errFormat
+		}
+		loc := tail[end-directory64LocLen:]
+		if binary.LittleEndian.Uint32(loc) != directory64LocSignature {
+			return nil, errFormat
+		}
+		off := int64(binary.LittleEndian.Uint64(loc[8:]))
+		if off < 0 || off+directory64EndLen > size {
+			return nil, errFormat
+		}
+		b := make([]byte, directory64EndLen)
+		if off >= tailOff {
+			copy(b, tail[off-tailOff:])
+		} else if _, err := r.ReadAt(b, off); err != nil && err != io.EOF {
+			return nil, err
+		}
+		if binary.LittleEndian.Uint32(b) != directory64EndSignature {
+			return nil, errFormat
+		}
+		count = binary.LittleEndian.Uint64(b[32:])
+		dirSize = binary.LittleEndian.Uint64(b[40:])
+		dirOff = binary.LittleEndian.Uint64(b[48:])
+	}
+	if dirOff > uint64(size) || dirSize > uint64(size)-dirOff || count > dirSize/directoryHeaderLen {
+		return nil, errFormat
+	}
+
+	var dir []byte
+	if int64(dirOff) >= tailOff {
+		dir = tail[int64(dirOff)-tailOff:]
+	} else {
+		dir = make([]byte, dirSize)
+		if _, err := r.ReadAt(dir, int64(dirOff)); err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+	dir = dir[:dirSize]
+
+	ix := &zipIndex{Size: size, Files: make([]zipEntry, 0, count)}
+	for i := uint64(0); i < count; i++ {
+		if len(dir) < directoryHeaderLen || binary.LittleEndian.Uint32(dir) != directoryHeaderSignature {
+			return nil, errFormat
+		}
+		nameLen := int(binary.LittleEndian.Uint16(dir[28:]))
+		extraLen := int(binary.LittleEndian.Uint16(dir[30:]))
+		commentLen := int(binary.LittleEndian.Uint16(dir[32:]))
+		n := directoryHeaderLen + nameLen + extraLen + commentLen
+		if len(dir) < n {
+			return nil, errFormat
+		}
+		f := zipEntry{
+			Method:           binary.LittleEndian.Uint16(dir[10:]),
+			CRC32:            binary.LittleEndian.Uint32(dir[16:]),
+			CompressedSize:   uint64(binary.LittleEndian.Uint32(dir[20:])),
+			UncompressedSize: uint64(binary.LittleEndian.Uint32(dir[24:])),
+			Offset:           int64(binary.LittleEndian.Uint32(dir[42:])),
+			Name:             string(dir[directoryHeaderLen : directoryHeaderLen+nameLen]),
+		}
+		extra := dir[directoryHeaderLen+nameLen : directoryHeaderLen+nameLen+extraLen]
+		for len(extra) >= 4 {
+			id := binary.LittleEndian.Uint16(extra)
+			n := int(binary.LittleEndian.Uint16(extra[2:]))
+			if 4+n > len(extra) {
+				break
+			}
+			if id == zip64ExtraID {
+				// Only the fields saturated in the fixed header are present, in this order.
+				b := extra[4 : 4+n]
+				if f.UncompressedSize == 0xFFFFFFFF && len(b) >= 8 {
+					f.UncompressedSize, b = binary.LittleEndian.Uint64(b), b[8:]
+				}
+				if f.CompressedSize == 0xFFFFFFFF && len(b) >= 8 {
+					f.CompressedSize, b = binary.LittleEndian.Uint64(b), b[8:]
+				}
+				if f.Offset == 0xFFFFFFFF && len(b) >= 8 {
+					f.Offset = int64(binary.LittleEndian.Uint64(b))
+				}
+			}
+			extra = extra[4+n:]
+		}
+		if f.Offset < 0 || f.Offset >= int64(dirOff) {
+			return nil, errFormat
+		}
+		ix.Files = append(ix.Files, f)
+		dir = dir[n:]
+	}
+
+	// Each file's data ends where the next file (in file order) begins,
+	// or at the start of the central directory.
+	order := make([]int, len(ix.Files))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return ix.Files[order[i]].Offset < ix.Files[order[j]].Offset })
+	for k, i := range order {
+		if k+1 < len(order) {
+			ix.Files[i].End = ix.Files[order[k+1]].Offset
+		} else {
+			ix.Files[i].End = int64(dirOff)
+		}
+	}
+	return ix, nil
+}
+
+// readFile returns the uncompressed content of f,
+// which must be an entry in ix,
+// using a single range request for the file's header and data
+// unless the raw data is in viewerCache.
+func (ix *zipIndex) readFile(ctx context.Context, f *zipEntry) ([]byte, error) {
+	if f.End-f.Offset < fileHeaderLen+int64(len(f.Name))+int64(f.CompressedSize) {
+		return nil, fmt.Errorf("%s: %s: %v", ix.URL, f.Name, errFormat)
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s#%d", ix.URL, f.Offset)))
+	key := fmt.Sprintf("zipdata.%x", sum[:])
+	buf, err := viewerCache.Get(ctx, key)
+	if err != nil || int64(len(buf)) != f.End-f.Offset {
+		buf = make([]byte, f.End-f.Offset)
+		if _, err := newReaderAt(ix.URL, ix.Size).ReadAt(buf, f.Offset); err != nil && err != io.EOF {
+			return nil, err
+		}
+		if len(buf) <= maxCachedData {
+			if err := viewerCache.Set(ctx, key, buf, dataTTL); err != nil {
+				log.Print(err)
+			}
+		}
+	}
+	if binary.LittleEndian.Uint32(buf) != fileHeaderSignature {
+		return nil, fmt.Errorf("%s: %s: %v", ix.URL, f.Name, errFormat)
+	}
+	start := fileHeaderLen + int64(binary.LittleEndian.Uint16(buf[26:])) + int64(binary.LittleEndian.Uint16(buf[28:]))
+	if start+int64(f.CompressedSize) > int64(len(buf)) {
+		return nil, fmt.Errorf("%s: %s: %v", ix.URL, f.Name, errFormat)
+	}
+	data := buf[start : start+int64(f.CompressedSize)]
+
+	switch f.Method {
+	case 0: // stored
+	case 8: // deflated
+		out := make([]byte, 0, f.UncompressedSize)
+		w := bytes.NewBuffer(out)
+		fr := flate.NewReader(bytes.NewReader(data))
+		_, err := io.Copy(w, io.LimitReader(fr, int64(f.UncompressedSize)+1))
+		fr.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %v", ix.URL, f.Name, err)
+		}
+		data = w.Bytes()
+	default:
+		return nil, fmt.Errorf("%s: %s: unsupported compression method %d", ix.URL, f.Name, f.Method)
+	}
+	if uint64(len(data)) != f.UncompressedSize || crc32.ChecksumIEEE(data) != f.CRC32 {
+		return nil, fmt.Errorf("%s: %s: checksum error", ix.URL, f.Name)
+	}
+	return data, nil
+}
+
+// openFile returns a reader for the content of f, streamed from the zip
+// with range reads rather than read into memory all at once.
+// The reader returns an error at the end of the file if the content
+// does not match the checksum in the zip index.
+func (ix *zipIndex) openFile(f *zipEntry) (io.ReadCloser, error) {
+	r := newReaderAt(ix.URL, ix.Size)
+	hdr := make([]byte, fileHeaderLen)
+	if _, err := r.ReadAt(hdr, f.Offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(hdr) != fileHeaderSignature {
+		return nil, fmt.Errorf("%s: %s: %v", ix.URL, f.Name, errFormat)
+	}
+	start := f.Offset + fileHeaderLen + int64(binary.LittleEndian.Uint16(hdr[26:])) + int64(binary.LittleEndian.Uint16(hdr[28:]))
+	if start+int64(f.CompressedSize) > f.End {
+		return nil, fmt.Errorf("%s: %s: %v", ix.URL, f.Name, errFormat)
+	}
+	// Large buffered reads bypass the remote reader's block cache,
+	// which would otherwise fill with the blocks of a single big file.
+	data := bufio.NewReaderSize(io.NewSectionReader(r, start, int64(f.CompressedSize)), maxBlockedRead+1)
+	var rc io.ReadCloser
+	switch f.Method {
+	case 0: // stored
+		rc = io.NopCloser(data)
+	case 8: // deflated
+		rc = flate.NewReader(data)
+	default:
+		return nil, fmt.Errorf("%s: %s: unsupported compression method %d", ix.URL, f.Name, f.Method)
+	}
+	return &checkedReader{rc: rc, ix: ix, f: f, crc: crc32.NewIEEE()}, nil
+}
+
+// A checkedReader reads a zip file's content, checking its size and checksum.
+type checkedReader struct {
+	rc  io.ReadCloser
+	ix  *zipIndex
+	f   *zipEntry
+	crc hash.Hash32
+	n   uint64
+}
+
+func (c *checkedReader) Read(b []byte) (int, error) {
+	n, err := c.rc.Read(b)
+	c.crc.Write(b[:n])
+	c.n += uint64(n)
+	if c.n > c.f.UncompressedSize || err == io.EOF && (c.n != c.f.UncompressedSize || c.crc.Sum32() != c.f.CRC32) {
+		return n, fmt.Errorf("%s: %s: checksum error", c.ix.URL, c.f.Name)
+	}
+	return n, err
+}
+
+func (c *checkedReader) Close() error {
+	return c.rc.Close()
+}
+
+// maxWholeZip is the largest zip that openZip will download.
+const maxWholeZip = 128 << 20
+
+// openZip downloads the whole zip and returns a reader for it,
+// for operations that need every file, such as hashing or indexing.
+func (ix *zipIndex) openZip() (*zip.Reader, error) {
+	if ix.Size > maxWholeZip {
+		return nil, fmt.Errorf("module zip too large (%s)", formatSize(uint64(ix.Size)))
+	}
+	data := make([]byte, ix.Size)
+	if _, err := newReaderAt(ix.URL, ix.Size).ReadAt(data, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), ix.Size)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", ix.URL, err)
+	}
+	return zr, nil
+}
@@ -0,0 +1,613 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package checkpoint reads and writes the tables of minimal formulas
+// written by boolean-oracle/compute and served by the boolean oracle.
+//
+// A checkpoint file holds the table of a given Kind (number of variables,
+// basis, metric and operator costs), as computed through a given level
+// (formula size, or cost in a table with costs, depth in a depth table,
+// or number of gates in a circuit table; see Metric and Costs).
+// All integers are big-endian uint32s. The file begins with a header:
+//
+//	magic     "boolckpt"
+//	version   format version, currently 1
+//	numVar    number of input variables, 1 to 5
+//	basis     basis flags, a Basis
+//	metric    measure of formulas minimized, a Metric
+//	costs     cost of each operator, 8 words, in the order of Costs
+//	level     last level in the table
+//	records   number of records
+//	checksum  CRC-32C (Castagnoli) of the whole file,
+//	          computed with this field set to zero
+//
+// The header is followed by level+1 counts, the number of records
+// of each size, and then by the records themselves, each written
+// as its F, P and Q, in the order they were found, which is by size.
+// In tables for bases with ternary operators (see Basis.Ternary),
+// each record also has its R, after Q.
+//
+// Write replaces files atomically, and Read checks the length
+// and checksum, so an interrupted write or a damaged file,
+// including a damaged header, results in an error,
+// not a partial or misinterpreted table.
+package checkpoint
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Version is the format version written by Write.
+const Version = 1
+
+const (
+	magic      = "boolckpt"
+	maxVar     = 5
+	headerSize = len(magic) + 15*4 // see the package comment
+)
+
+// A Basis is a set of flags describing the operators
+// allowed in the formulas in a table.
+// The zero Basis allows AND and OR of possibly negated inputs
+// and subformulas.
+// Nand and Nor replace AND and OR and cannot be combined
+// with the other flags.
+type Basis uint32
+
+const (
+	Xor  Basis = 1 << iota // also allow XOR
+	Nand                   // allow only NAND
+	Nor                    // allow only NOR
+	Mux                    // also allow MUX: P ? Q : R
+	Maj                    // also allow MAJ: the majority of P, Q and R
+)
+
+// basisNames are the names of the Basis flags, in bit order.
+var basisNames = []string{"xor", "nand", "nor", "mux", "maj"}
+
+// String returns the names of the flags in b, separated by commas,
+// or "and-or" for the zero Basis.
+func (b Basis) String() string {
+	if b == 0 {
+		return "and-or"
+	}
+	var names []string
+	for i, name := range basisNames {
+		if b&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	if extra := b &^ (1<<uint(len(basisNames)) - 1); extra != 0 {
+		names = append(names, fmt.Sprintf("%#x", uint32(extra)))
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseBasis parses a basis in the form returned by String.
+// The empty string is the zero Basis.
+func ParseBasis(s string) (Basis, error) {
+	var b Basis
+	if s == "" || s == "and-or" {
+		return b, nil
+	}
+Names:
+	for _, name := range strings.Split(s, ",") {
+		for i, n := range basisNames {
+			if name == n {
+				b |= 1 << uint(i)
+				continue Names
+			}
+		}
+		return 0, fmt.Errorf("unknown basis operator %q", name)
+	}
+	if err := b.Check(); err != nil {
+		return 0, err
+	}
+	return b, nil
+}
+
+// Check returns an error if b is not a valid basis.
+func (b Basis) Check() error {
+	if b>>uint(len(basisNames)) != 0 {
+		return fmt.Errorf("invalid basis %v", b)
+	}
+	if b&(Nand|Nor) != 0 && b&^Nand != 0 && b&^Nor != 0 {
+		return fmt.Errorf("invalid basis %v: nand and nor cannot be combined with other operators", b)
+	}
+	return nil
+}
+
+// NPN reports whether negating a formula is free in b, as it is
+// with AND and OR (by De Morgan's laws), so that the table can list
+// NPN classes of functions: those equivalent under negating and permuting
+// the inputs and negating the output. Otherwise, in the Nand and Nor bases,
+// the table lists NP classes, which do not include output negation.
+// Negating the inputs is free in every basis.
+func (b Basis) NPN() bool {
+	return b&(Nand|Nor) == 0
+}
+
+// Ternary reports whether b has operators with three operands,
+// so that the records in its tables have an R.
+func (b Basis) Ternary() bool {
+	return b&(Mux|Maj) != 0
+}
+
+// A Metric is the measure of the formulas, or circuits, that a table minimizes.
+type Metric uint32
+
+const (
+	Size    Metric = iota // number of operators
+	Depth                 // depth, and then number of operators
+	Circuit               // number of gates in a circuit, which can share results
+)
+
+// String returns "size", "depth" or "circuit".
+func (m Metric) String() string {
+	switch m {
+	case Size:
+		return "size"
+	case Depth:
+		return "depth"
+	case Circuit:
+		return "circuit"
+	}
+	return fmt.Sprintf("Metric(%d)", uint32(m))
+}
+
+// Costs gives the cost of each operator, for tables of formulas
+// of minimal total cost instead of minimal size, so that the tables
+// can match a library of gates of different costs.
+// A zero cost means the default, which is 1 for every operator but NOT,
+// so the zero Costs gives the size tables.
+// NOT is different: by default, negation is free, as it is with AND and OR
+// (see Basis.NPN), or, in the Nand and Nor bases, there is no NOT at all.
+// Giving NOT a cost makes it an operator like the others, applied to
+// subformulas, and the table then lists NP classes (see Costs.NPN).
+// Negating an input is free with any costs.
+//
+// With free negation, AND and OR can replace each other by De Morgan's
+// laws, so they must have the same cost. Tables with MUX or MAJ
+// must also have free negation.
+type Costs struct {
+	And, Or, Xor, Not, Nand, Nor, Mux, Maj uint32
+}
+
+// MaxCost is the largest cost of an operator.
+const MaxCost = 15
+
+// costNames are the names of the operators in Costs, in order.
+var costNames = []string{"and", "or", "xor", "not", "nand", "nor", "mux", "maj"}
+
+// fields returns pointers to the fields of c, in the order of costNames.
+func (c *Costs) fields() []*uint32 {
+	return []*uint32{&c.And, &c.Or, &c.Xor, &c.Not, &c.Nand, &c.Nor, &c.Mux, &c.Maj}
+}
+
+// String returns the non-default costs in c, as in "xor=2,not=1",
+// or "unit" for the zero Costs.
+func (c Costs) String() string {
+	var list []string
+	for i, p := range c.fields() {
+		if *p != 0 {
+			list = append(list, fmt.Sprintf("%s=%d", costNames[i], *p))
+		}
+	}
+	if len(list) == 0 {
+		return "unit"
+	}
+	return strings.Join(list, ",")
+}
+
+// ParseCosts parses costs in the form returned by String.
+// The empty string is the zero Costs. A cost equal to the
+// default is recorded as 0, so that equal costs compare equal.
+func ParseCosts(s string) (Costs, error) {
+	var c Costs
+	if s == "" || s == "unit" {
+		return c, nil
+	}
+	fields := c.fields()
+Costs:
+	for _, kv := range strings.Split(s, ",") {
+		i := strings.Index(kv, "=")
+		if i < 0 {
+			return Costs{}, fmt.Errorf("invalid cost %q: want op=cost", kv)
+		}
+		name := kv[:i]
+		n, err := strconv.ParseUint(kv[i+1:], 10, 32)
+		if err != nil || n > MaxCost {
+			return Costs{}, fmt.Errorf("invalid cost %q: want a number from 0 to %d", kv, MaxCost)
+		}
+		for j, cn := range costNames {
+			if name == cn {
+				if name != "not" && n == 1 {
+					n = 0
+				}
+				*fields[j] = uint32(n)
+				continue Costs
+			}
+		}
+		return Costs{}, fmt.Errorf("unknown operator %q", name)
+	}
+	return c, nil
+}
+
+// Check returns an error if c is not valid in basis b.
+func (c Costs) Check(b Basis) error {
+	for i, p := range c.fields() {
+		if *p > MaxCost {
+			return fmt.Errorf("invalid cost %s=%d", costNames[i], *p)
+		}
+	}
+	var missing []string
+	if b&(Nand|Nor) != 0 && (c.And != 0 || c.Or != 0) {
+		missing = append(missing, "and", "or")
+	}
+	for _, op := range []struct {
+		flag Basis
+		cost uint32
+		name string
+	}{
+		{Xor, c.Xor, "xor"},
+		{Nand, c.Nand, "nand"},
+		{Nor, c.Nor, "nor"},
+		{Mux, c.Mux, "mux"},
+		{Maj, c.Maj, "maj"},
+	} {
+		if b&op.flag == 0 && op.cost != 0 {
+			missing = append(missing, op.name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("invalid costs %v: basis %v has no %s", c, b, strings.Join(missing, ", "))
+	}
+	if c.NPN(b) && c.And != c.Or {
+		return fmt.Errorf("invalid costs %v: with free negation, and and or must cost the same", c)
+	}
+	if c.Not != 0 && b.Ternary() {
+		return fmt.Errorf("invalid costs %v: mux and maj require free negation", c)
+	}
+	return nil
+}
+
+// NPN reports whether negating a formula is free with costs c in basis b,
+// so that the table lists NPN classes, as described in Basis.NPN.
+func (c Costs) NPN(b Basis) bool {
+	return b.NPN() && c.Not == 0
+}
+
+// Effective returns c with the defaults filled in:
+// each zero cost but NOT's is replaced by 1.
+func (c Costs) Effective() Costs {
+	for i, p := range c.fields() {
+		if *p == 0 && costNames[i] != "not" {
+			*p = 1
+		}
+	}
+	return c
+}
+
+// A Record records a Boolean function F, as a truth table,
+// and the functions P, Q and, for ternary operators, R
+// it is computed from. For binary operators, R is 0.
+// For a literal, P is F and Q is 0 or the function that is always true.
+// For a NOT, which is an operator only if it has a cost, F is P negated
+// and Q is 0.
+type Record struct {
+	F, P, Q, R uint32
+}
+
+// recordSize returns the size of a record in a table with basis b.
+func recordSize(b Basis) int {
+	if b.Ternary() {
+		return 16
+	}
+	return 12
+}
+
+// A Kind identifies a table apart from its level.
+type Kind struct {
+	NumVar int
+	Basis  Basis
+	Metric Metric
+	Costs  Costs
+}
+
+// Check returns an error if k is not a valid kind of table.
+func (k Kind) Check() error {
+	if k.NumVar < 1 || k.NumVar > maxVar {
+		return fmt.Errorf("invalid number of variables %d", k.NumVar)
+	}
+	if err := k.Basis.Check(); err != nil {
+		return err
+	}
+	if k.Metric != Size && k.Metric != Depth && k.Metric != Circuit {
+		return fmt.Errorf("invalid metric %v", k.Metric)
+	}
+	if err := k.Costs.Check(k.Basis); err != nil {
+		return err
+	}
+	if k.Metric != Size && k.Costs != (Costs{}) {
+		return fmt.Errorf("invalid costs %v: %v tables have unit costs", k.Costs, k.Metric)
+	}
+	if k.Metric == Circuit && k.Basis.Ternary() {
+		return fmt.Errorf("invalid basis %v: circuit tables have only binary operators", k.Basis)
+	}
+	return nil
+}
+
+// A Table is the contents of a checkpoint.
+//
+// In a size table, there is one record for each class, and the level
+// of a record is the size of the class's minimal formulas,
+// or, in a table with costs, their total cost.
+//
+// In a depth table, the level of a record is a depth d, and the records
+// at level d are those for the classes whose smallest formulas of
+// depth at most d are smaller than those of depth at most d-1,
+// including the classes with no formula of depth d-1 at all.
+// So the first record for each class gives its minimal depth, and
+// a class may have records at several levels, of decreasing size.
+// The P, Q and R of a record at level d are computed by formulas
+// of depth at most d-1, using the latest record before level d for
+// each of their classes. The sizes are not recorded: each is one more
+// than the sum of the sizes of the operands.
+//
+// In a circuit table, the level of a class is the number of gates
+// in its minimal circuits, in which, unlike in formulas, the result
+// of a gate can be used more than once. The records at level g > 0
+// come in runs of g, one run for each class, listing the gates of
+// one of its minimal circuits in order: each record's F is computed
+// from P and Q, each of which is a literal or the F of an earlier
+// record in the run (or, with free negation, its negation),
+// and the last record's F is the class's function (or its negation).
+// At level 0, each class is a literal, with a record as in a size table.
+type Table struct {
+	Kind
+	Howto  []Record // records in order found
+	Counts []int    // Counts[i] is the number of records at level i
+}
+
+// Level returns the last level in t.
+func (t *Table) Level() int {
+	return len(t.Counts) - 1
+}
+
+// ErrCorrupt is returned (wrapped) by Read for files that are
+// truncated, damaged or otherwise not valid checkpoints.
+var ErrCorrupt = errors.New("corrupt checkpoint")
+
+// ErrMismatch is returned (wrapped) by Load for checkpoints
+// that are valid but hold a different table than expected.
+var ErrMismatch = errors.New("mismatched checkpoint")
+
+// Name returns the base name of the checkpoint file for the table
+// of kind k with the given level.
+// For example, the level 28 size table for 5 variables without XOR
+// is "a056287.5.28.ckpt", and the level 12 table with XOR
+// is "xor.a056287.5.12.ckpt". The other flags in the basis
+// are named the same way, in the order of basisNames,
+// as in "nand.a056287.4.30.ckpt" or "xor.mux.a056287.4.5.ckpt".
+// The names of depth tables begin with "depth.",
+// as in "depth.xor.a056287.4.4.ckpt", and those of circuit tables
+// with "circuit.", and the non-default costs follow the basis,
+// as in "xor.xor2.not1.a056287.4.9.ckpt".
+func Name(k Kind, level int) string {
+	prefix := ""
+	switch k.Metric {
+	case Depth:
+		prefix = "depth."
+	case Circuit:
+		prefix = "circuit."
+	}
+	for i, name := range basisNames {
+		if k.Basis&(1<<uint(i)) != 0 {
+			prefix += name + "."
+		}
+	}
+	for i, p := range k.Costs.fields() {
+		if *p != 0 {
+			prefix += fmt.Sprintf("%s%d.", costNames[i], *p)
+		}
+	}
+	return fmt.Sprintf("%sa056287.%d.%d.ckpt", prefix, k.NumVar, level)
+}
+
+// Write writes t to the file in dir named by Name.
+// It writes to a temporary file and then renames it,
+// so that the file is either replaced completely or not at all.
+func Write(dir string, t *Table) error {
+	if err := t.Kind.Check(); err != nil {
+		return fmt.Errorf("checkpoint: %v", err)
+	}
+	if len(t.Counts) == 0 {
+		return fmt.Errorf("checkpoint: invalid table (no levels)")
+	}
+	n := 0
+	for _, c := range t.Counts {
+		n += c
+	}
+	if n != len(t.Howto) {
+		return fmt.Errorf("checkpoint: invalid table (%d records, counts total %d)", len(t.Howto), n)
+	}
+
+	rsize := recordSize(t.Basis)
+	hsize := headerSize
+	data := make([]byte, hsize, hsize+4*len(t.Counts)+rsize*len(t.Howto))
+	for _, c := range t.Counts {
+		data = appendUint32(data, uint32(c))
+	}
+	for _, r := range t.Howto {
+		data = appendUint32(data, r.F)
+		data = appendUint32(data, r.P)
+		data = appendUint32(data, r.Q)
+		if rsize == 16 {
+			data = appendUint32(data, r.R)
+		}
+	}
+	copy(data, magic)
+	h := data[len(magic):]
+	binary.BigEndian.PutUint32(h[0:], Version)
+	binary.BigEndian.PutUint32(h[4:], uint32(t.NumVar))
+	binary.BigEndian.PutUint32(h[8:], uint32(t.Basis))
+	binary.BigEndian.PutUint32(h[12:], uint32(t.Metric))
+	for i, p := range t.Costs.fields() {
+		binary.BigEndian.PutUint32(h[16+4*i:], *p)
+	}
+	h = h[4*len(costNames):]
+	binary.BigEndian.PutUint32(h[16:], uint32(t.Level()))
+	binary.BigEndian.PutUint32(h[20:], uint32(len(t.Howto)))
+	binary.BigEndian.PutUint32(h[24:], checksum(data))
+
+	f, err := ioutil.TempFile(dir, ".ckpt-")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(0o644)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(dir, Name(t.Kind, t.Level())))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// checksumOffset is the offset of the checksum, the last word of the header.
+const checksumOffset = headerSize - 4
+
+// checksum returns the checksum of the checkpoint file data,
+// computed with the checksum field set to zero.
+func checksum(data []byte) uint32 {
+	var zero [4]byte
+	crc := crc32.Update(0, crcTable, data[:checksumOffset])
+	crc = crc32.Update(crc, crcTable, zero[:])
+	return crc32.Update(crc, crcTable, data[headerSize:])
+}
+
+func appendUint32(b []byte, x uint32) []byte {
+	return append(b, byte(x>>24), byte(x>>16), byte(x>>8), byte(x))
+}
+
+// Read reads the checkpoint in file.
+func Read(file string) (*Table, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	corrupt := func(msg string, args ...interface{}) error {
+		return fmt.Errorf("%s: %w: %s", file, ErrCorrupt, fmt.Sprintf(msg, args...))
+	}
+	if len(data) < headerSize || !bytes.HasPrefix(data, []byte(magic)) {
+		return nil, corrupt("bad header")
+	}
+	h := data[len(magic):]
+	u32 := func(i int) uint32 { return binary.BigEndian.Uint32(h[4*i:]) }
+	if version := u32(0); version != Version {
+		return nil, corrupt("unsupported version %d", version)
+	}
+	if checksum(data) != binary.BigEndian.Uint32(data[checksumOffset:]) {
+		return nil, corrupt("checksum mismatch")
+	}
+	k := Kind{NumVar: int(u32(1)), Basis: Basis(u32(2)), Metric: Metric(u32(3))}
+	for i, p := range k.Costs.fields() {
+		*p = u32(4 + i)
+	}
+	h = h[4*len(costNames):]
+	level, nrec := u32(4), u32(5)
+	if err := k.Check(); err != nil {
+		return nil, corrupt("%v", err)
+	}
+	rsize := recordSize(k.Basis)
+	body := data[headerSize:]
+	if want := 4*(uint64(level)+1) + uint64(rsize)*uint64(nrec); uint64(len(body)) != want {
+		return nil, corrupt("%d bytes of data, want %d", len(body), want)
+	}
+
+	t := &Table{
+		Kind:   k,
+		Howto:  make([]Record, nrec),
+		Counts: make([]int, level+1),
+	}
+	next := func() uint32 {
+		x := binary.BigEndian.Uint32(body)
+		body = body[4:]
+		return x
+	}
+	n := 0
+	for i := range t.Counts {
+		t.Counts[i] = int(next())
+		n += t.Counts[i]
+	}
+	if n != len(t.Howto) {
+		return nil, corrupt("%d records, counts total %d", len(t.Howto), n)
+	}
+	for i := range t.Howto {
+		r := &t.Howto[i]
+		r.F, r.P, r.Q = next(), next(), next()
+		if rsize == 16 {
+			r.R = next()
+		}
+	}
+	return t, nil
+}
+
+// Load reads the checkpoint in dir for the table of kind k
+// with the given level, as named by Name.
+// It checks that the file holds that table, in case it was renamed
+// or copied incorrectly.
+func Load(dir string, k Kind, level int) (*Table, error) {
+	file := filepath.Join(dir, Name(k, level))
+	t, err := Read(file)
+	if err != nil {
+		return nil, err
+	}
+	if t.Kind != k || t.Level() != level {
+		return nil, fmt.Errorf("%s: %w: holds %s", file, ErrMismatch, Name(t.Kind, t.Level()))
+	}
+	return t, nil
+}
+
+// Latest loads the checkpoint in dir with the highest level
+// for the table of kind k.
+func Latest(dir string, k Kind) (*Table, error) {
+	prefix := strings.TrimSuffix(Name(k, 0), "0.ckpt")
+	pattern := filepath.Join(dir, prefix+"*.ckpt")
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	level := -1
+	for _, file := range files {
+		var n int
+		if _, err := fmt.Sscanf(strings.TrimPrefix(filepath.Base(file), prefix), "%d.ckpt", &n); err == nil && n > level {
+			level = n
+		}
+	}
+	if level < 0 {
+		return nil, &os.PathError{Op: "open", Path: pattern, Err: os.ErrNotExist}
+	}
+	return Load(dir, k, level)
+}
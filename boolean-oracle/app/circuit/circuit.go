@@ -0,0 +1,337 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package circuit finds minimal Boolean circuits, in which,
+// unlike in the formulas of package table, the result of a gate
+// can be used by more than one later gate.
+//
+// A circuit is a list of gates, each applying a binary operator
+// of the basis to two earlier gates or input variables, and its size
+// is the number of gates. As in the formulas, negating an input variable
+// is free, and, except in the NAND and NOR bases, so is negating the
+// result of a gate.
+//
+// Find searches exhaustively for a circuit with at most a given number
+// of gates. The time it takes grows exponentially with the number of gates,
+// so it is practical only for functions of up to 4 variables, for which
+// boolean-oracle/compute -circuit writes tables of minimal circuits,
+// read by NewTable. For larger functions, Minimize starts with the minimal
+// formula from a table of package table, sharing its repeated subformulas,
+// and searches for smaller circuits for a limited number of steps.
+package circuit
+
+import (
+	"fmt"
+	"strings"
+
+	"rsc.io/swtch/boolean-oracle/app/table"
+)
+
+// A Wire connects an input variable or the result of a gate,
+// possibly negated, to a gate or to the output of the circuit.
+type Wire struct {
+	Node int  // input variable i is node i, and gate j is node NumVar+j
+	Neg  bool // whether the value is negated
+}
+
+// A Gate is a gate in a circuit.
+type Gate struct {
+	Op   string     // "&", "|", "^", "!&" (NAND) or "!|" (NOR)
+	Args [2]Wire    // operands, which are earlier in the circuit
+	F    table.Func // function computed by the gate
+}
+
+// A Circuit is a Boolean circuit.
+type Circuit struct {
+	NumVar int
+	F      table.Func // function computed by the circuit
+	Gates  []Gate     // in order: each gate uses only earlier ones
+	Out    Wire       // the output
+	Exact  bool       // whether the circuit is known to be minimal
+}
+
+// Size returns the number of gates in the circuit.
+func (c *Circuit) Size() int {
+	return len(c.Gates)
+}
+
+// Depth returns the depth of the circuit: the largest number
+// of gates on a path from an input variable to the output.
+func (c *Circuit) Depth() int {
+	depth := make([]int, len(c.Gates))
+	d := func(w Wire) int {
+		if w.Node < c.NumVar {
+			return 0
+		}
+		return depth[w.Node-c.NumVar]
+	}
+	for i, g := range c.Gates {
+		depth[i] = d(g.Args[0])
+		if d1 := d(g.Args[1]); d1 > depth[i] {
+			depth[i] = d1
+		}
+		depth[i]++
+	}
+	return d(c.Out)
+}
+
+// varNames are the names of the variables, as in package table.
+const varNames = "vwxyz"
+
+// Name returns the name of node n: the name of an input variable,
+// or g1, g2, and so on for the gates.
+func (c *Circuit) Name(n int) string {
+	if n < c.NumVar {
+		return varNames[n : n+1]
+	}
+	return fmt.Sprintf("g%d", n-c.NumVar+1)
+}
+
+// wire returns the name of the value on w, with ! for negation.
+func (c *Circuit) wire(w Wire) string {
+	if w.Neg {
+		return "!" + c.Name(w.Node)
+	}
+	return c.Name(w.Node)
+}
+
+// Lines returns the numbered gate list for the circuit,
+// with one line for each gate, such as "g2 = g1 | !x",
+// and a last line for the output, such as "out = !g2".
+// The operators are written as in package table's formulas.
+func (c *Circuit) Lines() []string {
+	var lines []string
+	for i, g := range c.Gates {
+		lines = append(lines, fmt.Sprintf("%s = %s %s %s", c.Name(c.NumVar+i), c.wire(g.Args[0]), g.Op, c.wire(g.Args[1])))
+	}
+	return append(lines, "out = "+c.wire(c.Out))
+}
+
+// String returns the gate list, one line for each gate, as in Lines.
+func (c *Circuit) String() string {
+	return strings.Join(c.Lines(), "\n") + "\n"
+}
+
+// DOT returns the circuit in the Graphviz DOT language.
+// The input variables and the output are plain text nodes,
+// the gates are boxes labeled with their names and operators,
+// and a negated wire ends in an empty circle,
+// like the bubble of an inverter.
+func (c *Circuit) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph circuit {\n")
+	b.WriteString("\trankdir=BT;\n")
+	used := make([]bool, c.NumVar)
+	for _, g := range c.Gates {
+		for _, w := range g.Args {
+			if w.Node < c.NumVar {
+				used[w.Node] = true
+			}
+		}
+	}
+	if c.Out.Node < c.NumVar {
+		used[c.Out.Node] = true
+	}
+	for i, u := range used {
+		if u {
+			fmt.Fprintf(&b, "\t%s [shape=plaintext];\n", c.Name(i))
+		}
+	}
+	for i, g := range c.Gates {
+		name := c.Name(c.NumVar + i)
+		fmt.Fprintf(&b, "\t%s [shape=box, label=\"%s\\n%s\"];\n", name, name, g.Op)
+	}
+	b.WriteString("\tout [shape=plaintext];\n")
+	edge := func(w Wire, to string) {
+		if w.Neg {
+			fmt.Fprintf(&b, "\t%s -> %s [arrowhead=odot];\n", c.Name(w.Node), to)
+		} else {
+			fmt.Fprintf(&b, "\t%s -> %s;\n", c.Name(w.Node), to)
+		}
+	}
+	for i, g := range c.Gates {
+		for _, w := range g.Args {
+			edge(w, c.Name(c.NumVar+i))
+		}
+	}
+	edge(c.Out, "out")
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// all returns the function of n variables that is always true.
+func all(n int) table.Func {
+	return table.Func(uint64(1)<<(1<<uint(n)) - 1)
+}
+
+// literal returns the function of n variables
+// that is equal to input variable i.
+func literal(n, i int) table.Func {
+	f := table.Func(0)
+	for k := 0; k < 1<<uint(n); k++ {
+		f |= table.Func((k>>uint(i))&1) << uint(k)
+	}
+	return f
+}
+
+// value returns the function on w, given the functions
+// computed by the gates before it.
+func (c *Circuit) value(w Wire, gates []table.Func) table.Func {
+	var f table.Func
+	if w.Node < c.NumVar {
+		f = literal(c.NumVar, w.Node)
+	} else {
+		f = gates[w.Node-c.NumVar]
+	}
+	if w.Neg {
+		f ^= all(c.NumVar)
+	}
+	return f
+}
+
+// eval sets the F of c and of its gates
+// to the functions they compute.
+func (c *Circuit) eval() {
+	gates := make([]table.Func, len(c.Gates))
+	for i := range c.Gates {
+		g := &c.Gates[i]
+		p, q := c.value(g.Args[0], gates), c.value(g.Args[1], gates)
+		switch g.Op {
+		case "&":
+			g.F = p & q
+		case "|":
+			g.F = p | q
+		case "^":
+			g.F = p ^ q
+		case "!&":
+			g.F = (p & q) ^ all(c.NumVar)
+		case "!|":
+			g.F = (p | q) ^ all(c.NumVar)
+		}
+		gates[i] = g.F
+	}
+	c.F = c.value(c.Out, gates)
+}
+
+// deMorgan rewrites the gates of c whose results are used only negated,
+// including as the output, to compute the negations instead,
+// using De Morgan's laws for AND and OR, and negating an operand
+// for XOR. It requires free negation: c must have no NAND or NOR gates.
+func (c *Circuit) deMorgan() {
+	for i := len(c.Gates) - 1; i >= 0; i-- {
+		n := c.NumVar + i
+		plain, neg := 0, 0
+		count := func(w Wire) {
+			if w.Node == n {
+				if w.Neg {
+					neg++
+				} else {
+					plain++
+				}
+			}
+		}
+		for _, g := range c.Gates[i+1:] {
+			count(g.Args[0])
+			count(g.Args[1])
+		}
+		count(c.Out)
+		if plain > 0 || neg == 0 {
+			continue
+		}
+		g := &c.Gates[i]
+		switch g.Op {
+		case "&", "|":
+			if g.Op == "&" {
+				g.Op = "|"
+			} else {
+				g.Op = "&"
+			}
+			g.Args[0].Neg = !g.Args[0].Neg
+			g.Args[1].Neg = !g.Args[1].Neg
+		case "^":
+			g.Args[1].Neg = !g.Args[1].Neg
+		default:
+			panic("circuit: deMorgan of " + g.Op)
+		}
+		flip := func(w *Wire) {
+			if w.Node == n {
+				w.Neg = false
+			}
+		}
+		for j := i + 1; j < len(c.Gates); j++ {
+			flip(&c.Gates[j].Args[0])
+			flip(&c.Gates[j].Args[1])
+		}
+		flip(&c.Out)
+	}
+	c.eval()
+}
+
+// rename returns a copy of c as a circuit of numVar variables,
+// in which variable i of c is replaced by variable vars[i],
+// negated if bit i of neg is set, and the output is negated
+// if negOut is set.
+func (c *Circuit) rename(numVar int, vars []int, neg int, negOut bool) *Circuit {
+	w := func(w Wire) Wire {
+		if w.Node < c.NumVar {
+			return Wire{vars[w.Node], w.Neg != (neg>>uint(w.Node)&1 != 0)}
+		}
+		return Wire{w.Node - c.NumVar + numVar, w.Neg}
+	}
+	r := &Circuit{NumVar: numVar, Out: w(c.Out), Exact: c.Exact}
+	r.Out.Neg = r.Out.Neg != negOut
+	for _, g := range c.Gates {
+		r.Gates = append(r.Gates, Gate{Op: g.Op, Args: [2]Wire{w(g.Args[0]), w(g.Args[1])}})
+	}
+	r.eval()
+	return r
+}
+
+// Transform returns a circuit for f, a function of c.NumVar variables,
+// made from c by negating and permuting its inputs and, with free
+// negation in s, its output, or nil if f is not in the class of c.F
+// in s (see table.Space). With free negation, the gates are rewritten
+// as in deMorgan.
+func Transform(s *table.Space, c *Circuit, f table.Func) *Circuit {
+	vars := make([]int, c.NumVar)
+	for i := range vars {
+		vars[i] = i
+	}
+	r := c.transform(s, f, c.NumVar, vars)
+	if r == nil || r.F != f {
+		return nil
+	}
+	return r
+}
+
+// transform returns a circuit for g, a function of c.NumVar variables
+// in the class of c.F in s, as a circuit of numVar variables in which
+// variable i of g is variable vars[i], or nil if g is not in that class.
+func (c *Circuit) transform(s *table.Space, g table.Func, numVar int, vars []int) *Circuit {
+	// Find the transformation from c.F to g, and apply it to the
+	// circuit, renaming the variables of g to those given by vars.
+	var r *Circuit
+	s.Transforms(g, func(h table.Func, perm []int, neg int) {
+		if r != nil || h != c.F && !(s.NPN() && h == c.F^s.All()) {
+			return
+		}
+		// h(x) = g(y) with y[perm[i]] = x[i] ^ neg[i],
+		// so g(y) = c.F(x) with x[i] = y[perm[i]] ^ neg[i],
+		// and variable i of c becomes variable perm[i] of g,
+		// which is variable vars[perm[i]] of the result.
+		// Only the circuits for the constants, like v & !v,
+		// use variables that their functions do not depend on,
+		// and for those, any variable will do.
+		names := make([]int, c.NumVar)
+		for i, p := range perm {
+			if p < len(vars) {
+				names[i] = vars[p]
+			}
+		}
+		r = c.rename(numVar, names, neg, h != c.F)
+	})
+	if r != nil && s.NPN() {
+		r.deMorgan()
+	}
+	return r
+}
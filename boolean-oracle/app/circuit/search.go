@@ -0,0 +1,518 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package circuit
+
+import (
+	"fmt"
+
+	"rsc.io/swtch/boolean-oracle/app/checkpoint"
+	"rsc.io/swtch/boolean-oracle/app/table"
+)
+
+// Find returns a circuit for f, a function of numVar variables,
+// with at most max gates using the operators of basis b,
+// and with as few gates as possible for that bound,
+// or nil if there is none.
+// The basis must not have ternary operators.
+// The search is exhaustive, and its time grows quickly with max:
+// for 4 variables, each gate multiplies it by about 30.
+func Find(numVar int, b checkpoint.Basis, f table.Func, max int) *Circuit {
+	if c := special(numVar, b, f); c != nil {
+		if c.Size() > max {
+			return nil
+		}
+		return c
+	}
+	s := newSearch(numVar, b, f)
+	for n := s.lower(); n <= max; n++ {
+		if c := s.run(n); c != nil {
+			return c
+		}
+	}
+	return nil
+}
+
+// Minimize returns the smallest circuit it can find for f,
+// using the basis of t, which must be a size table with the default
+// costs in a basis without ternary operators.
+// It starts with the minimal formula for f, sharing repeated
+// subformulas, and searches for smaller circuits, stopping after
+// trying limit gates, or never if limit is 0.
+// Lower is a known lower bound on the number of gates,
+// such as one more than the last level of a circuit table
+// that does not list f, or else 0.
+// The circuit is Exact if the search shows that there is no smaller one.
+// With free negation, the gates are rewritten as in deMorgan.
+func Minimize(t *table.Table, f table.Func, lower int, limit int64) (*Circuit, error) {
+	if t.Metric != checkpoint.Size || t.Costs != (checkpoint.Costs{}) {
+		return nil, fmt.Errorf("circuits need a size table with the default costs")
+	}
+	if t.Basis.Ternary() {
+		return nil, fmt.Errorf("circuits cannot use the ternary operators of basis %v", t.Basis)
+	}
+	if f&^t.All() != 0 {
+		return nil, fmt.Errorf("function %#x has too many bits for %d variables", uint32(f), t.NumVar)
+	}
+	if c := special(t.NumVar, t.Basis, f); c != nil {
+		return c, nil
+	}
+	tree, err := t.Tree(f)
+	if err != nil {
+		return nil, err
+	}
+	best := fromTree(t, tree)
+	s := newSearch(t.NumVar, t.Basis, f)
+	s.limit = limit
+	if lower < s.lower() {
+		lower = s.lower()
+	}
+	best.Exact = true
+	for best.Size() > lower {
+		c := s.run(best.Size() - 1)
+		if c == nil {
+			best.Exact = !s.stopped
+			break
+		}
+		best = c
+	}
+	if s.free {
+		best.deMorgan()
+	}
+	return best, nil
+}
+
+// special returns the minimal circuit for f if it is a literal
+// or a constant, which the search does not handle, or else nil.
+func special(numVar int, b checkpoint.Basis, f table.Func) *Circuit {
+	all := all(numVar)
+	for i := 0; i < numVar; i++ {
+		if x := literal(numVar, i); f == x || f == x^all {
+			return &Circuit{NumVar: numVar, F: f, Out: Wire{i, f != x}, Exact: true}
+		}
+	}
+	if f != 0 && f != all {
+		return nil
+	}
+
+	// A constant takes one gate, x & !x, with free negation.
+	// Otherwise, one gate gives true for NAND or false for NOR,
+	// and a second gate negates it.
+	c := &Circuit{NumVar: numVar, Exact: true}
+	v, nv := Wire{0, false}, Wire{0, true}
+	g1 := Wire{Node: numVar}
+	switch {
+	case b&checkpoint.Nand != 0:
+		c.Gates = append(c.Gates, Gate{Op: "!&", Args: [2]Wire{v, nv}})
+		if f == 0 {
+			c.Gates = append(c.Gates, Gate{Op: "!&", Args: [2]Wire{g1, g1}})
+		}
+	case b&checkpoint.Nor != 0:
+		c.Gates = append(c.Gates, Gate{Op: "!|", Args: [2]Wire{v, nv}})
+		if f == all {
+			c.Gates = append(c.Gates, Gate{Op: "!|", Args: [2]Wire{g1, g1}})
+		}
+	default:
+		c.Gates = append(c.Gates, Gate{Op: "&", Args: [2]Wire{v, nv}})
+		g1.Neg = f == all
+	}
+	c.Out = Wire{numVar + len(c.Gates) - 1, g1.Neg}
+	c.eval()
+	return c
+}
+
+// fromTree returns the circuit computing the formula x,
+// with a gate for each operator of x, except that repeated
+// subformulas, or with free negation, negated ones, share a gate.
+func fromTree(t *table.Table, x *table.Tree) *Circuit {
+	c := &Circuit{NumVar: t.NumVar}
+	all := t.All()
+	free := t.Basis.NPN()
+	gates := make(map[table.Func]int)
+	var walk func(x *table.Tree) Wire
+	walk = func(x *table.Tree) Wire {
+		if x.Op == "Lit" {
+			return Wire{x.Var, x.Neg}
+		}
+		if n, ok := gates[x.F]; ok {
+			return Wire{n, false}
+		}
+		if n, ok := gates[x.F^all]; ok && free {
+			return Wire{n, true}
+		}
+		g := Gate{Op: x.Op}
+		for i, y := range x.Args {
+			g.Args[i] = walk(y)
+		}
+		n := c.NumVar + len(c.Gates)
+		c.Gates = append(c.Gates, g)
+		gates[x.F] = n
+		return Wire{n, false}
+	}
+	c.Out = walk(x)
+	c.eval()
+	return c
+}
+
+// A search is the state of the search for a circuit for f
+// with at most a given number of gates.
+//
+// The search builds the circuit one gate at a time, trying every
+// operator and pair of earlier values for each, starting with the
+// variables that f depends on. To avoid trying the same circuit
+// in more than one order, a gate that does not use the gate just
+// before it must come after that gate in the order of their keys
+// (see sgate.key); any circuit can be put in that order by moving
+// such gates earlier. The search skips gates that compute
+// a constant, a literal, f or a value already computed,
+// and it requires that every gate but the last be used
+// by a later one, since otherwise the circuit would not be minimal.
+type search struct {
+	numVar  int
+	all     table.Func
+	f       table.Func
+	free    bool // whether negating a gate's result is free
+	basis   checkpoint.Basis
+	lits    []table.Func // the literals of all the variables
+	vars    []int        // the variables that f depends on
+	limit   int64        // maximum number of steps, or 0 for no limit
+	steps   int64        // number of gates tried
+	stopped bool         // whether the search stopped at the limit
+
+	max    int          // maximum number of gates
+	vals   []table.Func // values of the variables in vars, then of the gates
+	gates  []sgate      // the gates so far
+	uses   []int        // number of gates using each value
+	unused int          // number of values not used by any gate
+	found  *Circuit     // the circuit found, if any
+}
+
+// An sgate is a gate in the search, applying op to values j and k.
+// For the NAND and NOR bases, op holds the negations of the operands,
+// which are allowed only for variables.
+type sgate struct {
+	j, k int
+	op   uint8
+}
+
+// The operators for bases with free negation.
+// Up to negation of the result, they compute every function
+// of two values using AND, OR and, in the Xor basis, XOR.
+const (
+	opAnd    = iota // j & k
+	opAndNot        // j & !k
+	opNotAnd        // !j & k
+	opOr            // j | k
+	opXor           // j ^ k
+	numFreeOps
+)
+
+// The negations of the operands of a NAND or NOR.
+const (
+	negJ = 1 << iota
+	negK
+	numNegOps = 4
+)
+
+// key returns the order of g among the gates in the search.
+func (g sgate) key() int {
+	return (g.k<<8|g.j)<<8 | int(g.op)
+}
+
+// newSearch returns a search for f, a function of numVar variables
+// that is neither a literal nor a constant, in basis b.
+func newSearch(numVar int, b checkpoint.Basis, f table.Func) *search {
+	s := &search{
+		numVar: numVar,
+		all:    all(numVar),
+		f:      f,
+		free:   b.NPN(),
+		basis:  b,
+	}
+	for i := 0; i < numVar; i++ {
+		x := literal(numVar, i)
+		s.lits = append(s.lits, x)
+		if depends(f, x, i) {
+			s.vars = append(s.vars, i)
+		}
+	}
+	return s
+}
+
+// depends reports whether f depends on variable i, whose literal is x:
+// whether the bits of f where x is set, shifted down to the matching
+// bits where it is clear, differ from those.
+func depends(f, x table.Func, i int) bool {
+	return (f&x)>>(1<<uint(i)) != f&^x
+}
+
+// lower returns a lower bound on the number of gates for f:
+// every gate combines two values, so a connected circuit
+// using all the variables of f has at least one fewer gates.
+func (s *search) lower() int {
+	if len(s.vars) < 2 {
+		return 1
+	}
+	return len(s.vars) - 1
+}
+
+// numOps returns the number of operators to try.
+func (s *search) numOps() uint8 {
+	switch {
+	case !s.free:
+		return numNegOps
+	case s.basis&checkpoint.Xor != 0:
+		return numFreeOps
+	}
+	return opXor
+}
+
+// value returns the function computed by g,
+// and whether it is a gate in the basis.
+func (s *search) value(g sgate) (table.Func, bool) {
+	a, b := s.vals[g.j], s.vals[g.k]
+	if !s.free {
+		nv := len(s.vars)
+		if g.op&negJ != 0 {
+			if g.j >= nv {
+				return 0, false
+			}
+			a ^= s.all
+		}
+		if g.op&negK != 0 {
+			if g.k >= nv {
+				return 0, false
+			}
+			b ^= s.all
+		}
+		if s.basis&checkpoint.Nand != 0 {
+			return (a & b) ^ s.all, true
+		}
+		return (a | b) ^ s.all, true
+	}
+	switch g.op {
+	case opAnd:
+		return a & b, true
+	case opAndNot:
+		return a &^ b, true
+	case opNotAnd:
+		return b &^ a, true
+	case opOr:
+		return a | b, true
+	}
+	return a ^ b, true
+}
+
+// useless reports whether a gate computing v is of no use in a minimal
+// circuit other than as the last gate: whether v is a constant, a literal,
+// a value already computed or f, or, with free negation, the negation of one.
+func (s *search) useless(v table.Func) bool {
+	same := func(x table.Func) bool {
+		return v == x || s.free && v == x^s.all
+	}
+	if v == 0 || v == s.all || same(s.f) {
+		return true
+	}
+	for _, x := range s.lits {
+		if v == x || v == x^s.all {
+			return true
+		}
+	}
+	for _, x := range s.vals[len(s.vars):] {
+		if same(x) {
+			return true
+		}
+	}
+	return false
+}
+
+// run searches for a circuit for f with at most max gates,
+// returning the circuit found, or nil.
+func (s *search) run(max int) *Circuit {
+	s.max = max
+	s.found = nil
+	s.gates = s.gates[:0]
+	s.vals = s.vals[:0]
+	for _, i := range s.vars {
+		s.vals = append(s.vals, s.lits[i])
+	}
+	s.uses = make([]int, len(s.vars), len(s.vars)+max)
+	s.unused = len(s.vars)
+	s.explore()
+	return s.found
+}
+
+// add adds g, which computes v, to the circuit.
+func (s *search) add(g sgate, v table.Func) {
+	s.gates = append(s.gates, g)
+	s.vals = append(s.vals, v)
+	s.uses = append(s.uses, 0)
+	s.unused++
+	s.use(g.j, 1)
+	if g.k != g.j {
+		s.use(g.k, 1)
+	}
+}
+
+// remove removes the last gate from the circuit.
+func (s *search) remove() {
+	g := s.gates[len(s.gates)-1]
+	s.use(g.j, -1)
+	if g.k != g.j {
+		s.use(g.k, -1)
+	}
+	s.gates = s.gates[:len(s.gates)-1]
+	s.vals = s.vals[:len(s.vals)-1]
+	s.uses = s.uses[:len(s.uses)-1]
+	s.unused--
+}
+
+// use adds d to the uses of value i.
+func (s *search) use(i, d int) {
+	if s.uses[i] == 0 {
+		s.unused--
+	}
+	s.uses[i] += d
+	if s.uses[i] == 0 {
+		s.unused++
+	}
+}
+
+// explore extends the circuit so far, setting s.found
+// and returning true if it finds a circuit for f.
+func (s *search) explore() bool {
+	if s.limit > 0 && s.steps >= s.limit {
+		s.stopped = true
+		return false
+	}
+	if s.finish() {
+		return true
+	}
+
+	// Each gate uses at most two unused values and adds one,
+	// and at the end the only unused value is the last gate,
+	// so the gates to come, including the last, must be able
+	// to use up the unused values.
+	n := len(s.vals)
+	rest := s.max - len(s.gates) - 1 // gates that can come before the last
+	if rest < 1 || s.unused > rest+2 {
+		return false
+	}
+	last, k0 := -1, 0
+	if len(s.gates) > 0 {
+		g := s.gates[len(s.gates)-1]
+		last, k0 = g.key(), g.k
+	}
+	numOps := s.numOps()
+	for k := k0; k < n; k++ {
+		for j := 0; j <= k; j++ {
+			if j == k && (s.free || k < len(s.vars)) {
+				// Only a NAND or NOR of a gate with itself,
+				// to negate it, is of any use.
+				continue
+			}
+			for op := uint8(0); op < numOps; op++ {
+				g := sgate{j, k, op}
+				if k != n-1 && g.key() <= last {
+					continue
+				}
+				v, ok := s.value(g)
+				if !ok || s.useless(v) {
+					continue
+				}
+				s.steps++
+				s.add(g, v)
+				if s.unused <= rest+1 && s.explore() {
+					return true
+				}
+				s.remove()
+				if s.stopped {
+					return false
+				}
+			}
+		}
+	}
+	return false
+}
+
+// finish reports whether one more gate, using every unused value,
+// can compute f, and if so, records the circuit in s.found.
+func (s *search) finish() bool {
+	if len(s.gates) >= s.max || s.unused > 2 {
+		return false
+	}
+	var unused []int
+	for i, u := range s.uses {
+		if u == 0 {
+			unused = append(unused, i)
+		}
+	}
+	numOps := s.numOps()
+	for k := 0; k < len(s.vals); k++ {
+	Pairs:
+		for j := 0; j <= k; j++ {
+			if j == k && (s.free || k < len(s.vars)) {
+				continue
+			}
+			for _, u := range unused {
+				if u != j && u != k {
+					continue Pairs
+				}
+			}
+			for op := uint8(0); op < numOps; op++ {
+				g := sgate{j, k, op}
+				v, ok := s.value(g)
+				if ok && (v == s.f || s.free && v == s.f^s.all) {
+					s.add(g, v)
+					s.found = s.circuit()
+					s.remove()
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// circuit returns the Circuit for the gates in the search,
+// the last of which computes f or, with free negation, its negation.
+func (s *search) circuit() *Circuit {
+	c := &Circuit{NumVar: s.numVar}
+	nv := len(s.vars)
+	node := func(i int) int {
+		if i < nv {
+			return s.vars[i]
+		}
+		return s.numVar + i - nv
+	}
+	for _, g := range s.gates {
+		a := Wire{Node: node(g.j)}
+		b := Wire{Node: node(g.k)}
+		var op string
+		if s.free {
+			switch g.op {
+			case opAnd:
+				op = "&"
+			case opAndNot:
+				op, b.Neg = "&", true
+			case opNotAnd:
+				op, a.Neg = "&", true
+			case opOr:
+				op = "|"
+			case opXor:
+				op = "^"
+			}
+		} else {
+			a.Neg, b.Neg = g.op&negJ != 0, g.op&negK != 0
+			op = "!|"
+			if s.basis&checkpoint.Nand != 0 {
+				op = "!&"
+			}
+		}
+		c.Gates = append(c.Gates, Gate{Op: op, Args: [2]Wire{a, b}})
+	}
+	last := s.numVar + len(c.Gates) - 1
+	c.Out = Wire{last, s.vals[len(s.vals)-1] != s.f}
+	c.eval()
+	return c
+}
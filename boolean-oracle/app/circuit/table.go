@@ -0,0 +1,200 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package circuit
+
+import (
+	"fmt"
+
+	"rsc.io/swtch/boolean-oracle/app/checkpoint"
+	"rsc.io/swtch/boolean-oracle/app/table"
+)
+
+// A Table is a table of minimal circuits, one for each class of functions
+// (see package table), held in a circuit table (see checkpoint.Table).
+type Table struct {
+	checkpoint.Kind
+	Circuits []*Circuit // in the order of the records, so by size
+
+	level   int
+	byClass map[table.Func]*Circuit
+	space   *table.Space
+}
+
+// NewTable returns the table held in the checkpoint t.
+func NewTable(t *checkpoint.Table) (*Table, error) {
+	if t.Metric != checkpoint.Circuit {
+		return nil, fmt.Errorf("circuit: %s is not a circuit table", checkpoint.Name(t.Kind, t.Level()))
+	}
+	if err := t.Kind.Check(); err != nil {
+		return nil, fmt.Errorf("circuit: %v", err)
+	}
+	tab := &Table{
+		Kind:    t.Kind,
+		level:   t.Level(),
+		byClass: make(map[table.Func]*Circuit),
+		space:   table.NewSpace(t.NumVar, t.Basis.NPN()),
+	}
+	h := t.Howto
+	for level, n := range t.Counts {
+		run := level
+		if run == 0 {
+			run = 1
+		}
+		if n%run != 0 {
+			return nil, fmt.Errorf("circuit: %d records at level %d, not a multiple of %d", n, level, run)
+		}
+		for ; n > 0; n -= run {
+			c, err := tab.fromRecords(h[:run], level == 0)
+			if err != nil {
+				return nil, fmt.Errorf("circuit: level %d: %v", level, err)
+			}
+			class := tab.Canon(c.F)
+			if tab.byClass[class] != nil {
+				return nil, fmt.Errorf("circuit: level %d: second circuit for %s", level, tab.Format(class))
+			}
+			c.Exact = true
+			tab.Circuits = append(tab.Circuits, c)
+			tab.byClass[class] = c
+			h = h[run:]
+		}
+	}
+	return tab, nil
+}
+
+// Level returns the last level in t. The classes not in t
+// have no circuits with that many gates or fewer.
+func (t *Table) Level() int {
+	return t.level
+}
+
+// Format returns the hexadecimal form of f,
+// with as many digits as the table's functions have.
+func (t *Table) Format(f table.Func) string {
+	return t.space.Format(f)
+}
+
+// fromRecords returns the circuit recorded in recs,
+// which is a literal if lit is set.
+func (t *Table) fromRecords(recs []checkpoint.Record, lit bool) (*Circuit, error) {
+	n := t.NumVar
+	all := all(n)
+	c := &Circuit{NumVar: n}
+	wire := func(x table.Func) (Wire, bool) {
+		for i := 0; i < n; i++ {
+			if l := literal(n, i); x == l || x == l^all {
+				return Wire{i, x != l}, true
+			}
+		}
+		for i, g := range c.Gates {
+			if x == g.F || t.Basis.NPN() && x == g.F^all {
+				return Wire{n + i, x != g.F}, true
+			}
+		}
+		return Wire{}, false
+	}
+	if lit {
+		f := table.Func(recs[0].F)
+		w, ok := wire(f)
+		if !ok || w.Node >= n || recs[0].P != recs[0].F {
+			return nil, fmt.Errorf("record for %s is not a literal", t.Format(f))
+		}
+		c.Out = w
+		c.eval()
+		return c, nil
+	}
+	for _, r := range recs {
+		f, p, q := table.Func(r.F), table.Func(r.P), table.Func(r.Q)
+		var op string
+		switch b := t.Basis; {
+		case b&checkpoint.Nand != 0 && f == (p&q)^all:
+			op = "!&"
+		case b&checkpoint.Nor != 0 && f == (p|q)^all:
+			op = "!|"
+		case b&(checkpoint.Nand|checkpoint.Nor) != 0:
+		case f == p&q:
+			op = "&"
+		case f == p|q:
+			op = "|"
+		case b&checkpoint.Xor != 0 && f == p^q:
+			op = "^"
+		}
+		if op == "" {
+			return nil, fmt.Errorf("cannot determine operator for %s from %s and %s", t.Format(f), t.Format(p), t.Format(q))
+		}
+		wp, okp := wire(p)
+		wq, okq := wire(q)
+		if !okp || !okq {
+			return nil, fmt.Errorf("operands of %s are not literals or earlier gates", t.Format(f))
+		}
+		c.Gates = append(c.Gates, Gate{Op: op, Args: [2]Wire{wp, wq}, F: f})
+	}
+	c.Out = Wire{Node: n + len(c.Gates) - 1}
+	c.eval()
+	if class := t.Canon(c.F); class != c.F && t.Basis.NPN() && class == c.F^all {
+		c.Out.Neg = true
+		c.eval()
+	}
+	return c, nil
+}
+
+// Records returns the records for c in a circuit table:
+// a literal's record, or one record for each gate.
+func Records(c *Circuit) []checkpoint.Record {
+	if len(c.Gates) == 0 {
+		return []checkpoint.Record{{F: uint32(c.F), P: uint32(c.F)}}
+	}
+	var recs []checkpoint.Record
+	var gates []table.Func
+	for _, g := range c.Gates {
+		p, q := c.value(g.Args[0], gates), c.value(g.Args[1], gates)
+		recs = append(recs, checkpoint.Record{F: uint32(g.F), P: uint32(p), Q: uint32(q)})
+		gates = append(gates, g.F)
+	}
+	return recs
+}
+
+// Canon returns the canonical form of f, a function of t.NumVar
+// variables: the smallest function in its class.
+func (t *Table) Canon(f table.Func) table.Func {
+	return t.space.Canon(f)
+}
+
+// ClassSize returns the number of functions in the class of f.
+func (t *Table) ClassSize(f table.Func) int {
+	return t.space.ClassSize(f)
+}
+
+// Lower returns a lower bound on the number of gates in circuits
+// for f, a function of numVar variables: the size of its minimal
+// circuit if it is in t, one more than t.Level() if it is not but
+// depends on at most t.NumVar variables, and 0 otherwise.
+func (t *Table) Lower(f table.Func, numVar int) int {
+	if c := t.Lookup(f, numVar); c != nil {
+		return c.Size()
+	}
+	if _, _, ok := table.Project(f, numVar, t.NumVar); ok {
+		return t.level + 1
+	}
+	return 0
+}
+
+// Lookup returns the minimal circuit for f, a function of numVar variables,
+// or nil if t has none: if f depends on more than t.NumVar variables,
+// or if its class is not in t, because it needs more than t.Level() gates.
+// With free negation, the gates are rewritten as in deMorgan.
+func (t *Table) Lookup(f table.Func, numVar int) *Circuit {
+	vars, g, ok := table.Project(f, numVar, t.NumVar)
+	if !ok {
+		return nil
+	}
+	c := t.byClass[t.Canon(g)]
+	if c == nil {
+		return nil
+	}
+	r := c.transform(t.space, g, numVar, vars)
+	if r == nil || r.F != f {
+		panic("circuit: lookup did not find transformation")
+	}
+	return r
+}
@@ -0,0 +1,115 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package table
+
+import (
+	"fmt"
+	"math/bits"
+
+	"rsc.io/swtch/boolean-oracle/app/checkpoint"
+)
+
+// maxEnumerate is the largest number of don't-cares
+// for which Complete tries every completion.
+const maxEnumerate = 12
+
+// Complete returns the completion of the incompletely specified
+// function f, which is given only where care is set: the function
+// that agrees with f there and has the cheapest formula in t,
+// of minimal size, or in a table with costs, of minimal cost.
+// Among equally cheap completions, it returns the smallest.
+// The table must be a size table (possibly with costs).
+// If it stops before the last level, Complete considers
+// only the completions in the table, which are cheaper
+// than all the others.
+//
+// With few don't-cares, Complete looks up every completion.
+// With more, it tries the classes in order of cost, stopping
+// after the cheapest that has a member agreeing with f.
+func (t *Table) Complete(f, care Func) (Func, error) {
+	if t.Metric != checkpoint.Size {
+		return 0, fmt.Errorf("completing a function needs a size table, not a %v table", t.Metric)
+	}
+	all := t.s.all
+	if f&^all != 0 || care&^all != 0 {
+		return 0, fmt.Errorf("function %#x or care mask %#x has too many bits for %d variables", uint32(f), uint32(care), t.NumVar)
+	}
+	f &= care
+	dc := all &^ care
+	if bits.OnesCount32(uint32(dc)) <= maxEnumerate {
+		return t.completeEach(f, care)
+	}
+	return t.completeByCost(f, care)
+}
+
+// completeEach returns the cheapest completion of f,
+// which is specified where care is set, trying each one.
+func (t *Table) completeEach(f, care Func) (Func, error) {
+	dc := t.s.all &^ care
+	best, bestCost := Func(0), -1
+	// Visit the subsets of dc in increasing order.
+	for sub := Func(0); ; sub = (sub - dc) & dc {
+		g := f | sub
+		e := t.Lookup(t.Canon(g))
+		if e != nil && (bestCost < 0 || e.Cost < bestCost) {
+			best, bestCost = g, e.Cost
+		}
+		if sub == dc {
+			break
+		}
+	}
+	if bestCost < 0 {
+		return 0, fmt.Errorf("no table entry agrees with %s on %s", t.Format(f), t.Format(care))
+	}
+	return best, nil
+}
+
+// completeByCost returns the cheapest completion of f,
+// which is specified where care is set, by finding the
+// cheapest classes with a member agreeing with f.
+func (t *Table) completeByCost(f, care Func) (Func, error) {
+	// Sort the entries by cost, keeping the order by F.
+	maxCost := 0
+	for i := range t.Entries {
+		if c := t.Entries[i].Cost; c > maxCost {
+			maxCost = c
+		}
+	}
+	start := make([]int, maxCost+2)
+	for i := range t.Entries {
+		start[t.Entries[i].Cost+1]++
+	}
+	for c := 1; c < len(start); c++ {
+		start[c] += start[c-1]
+	}
+	byCost := make([]*Entry, len(t.Entries))
+	next := append([]int(nil), start...)
+	for i := range t.Entries {
+		e := &t.Entries[i]
+		byCost[next[e.Cost]] = e
+		next[e.Cost]++
+	}
+
+	for c := 0; c <= maxCost; c++ {
+		found := false
+		var best Func
+		try := func(g Func) {
+			if (g^f)&care == 0 && (!found || g < best) {
+				best, found = g, true
+			}
+		}
+		for _, e := range byCost[start[c]:start[c+1]] {
+			t.s.walk(e.F, nil, func(g, mask Func) {
+				try(g)
+				if t.s.neg != 0 {
+					try(g ^ t.s.all)
+				}
+			})
+		}
+		if found {
+			return best, nil
+		}
+	}
+	return 0, fmt.Errorf("no table entry agrees with %s on %s", t.Format(f), t.Format(care))
+}
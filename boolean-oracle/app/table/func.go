@@ -0,0 +1,343 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package table
+
+import "fmt"
+
+// A Func represents a single boolean function.
+// It specifies only the outputs for each input,
+// not a way to compute it: bit k of the Func is
+// the output for the input k, in which bit i is
+// the value of variable i.
+type Func uint32
+
+// maxVar is the largest number of variables a Func can represent.
+const maxVar = 5
+
+// A space holds the values and tables for manipulating
+// the functions of a given number of variables.
+// See boolean-oracle/compute's func.go for details.
+type space struct {
+	numVar     int
+	numInput   int
+	all        Func // function with every truth table bit set
+	top        uint // index of the top truth table bit
+	neg        Func // all if negating the output is free, 0 otherwise
+	grayBit    []uint8
+	invert     []invertOp
+	swap       []swapOp
+	permuteBit []int
+}
+
+type invertOp struct {
+	mask  Func
+	shift uint
+}
+
+type swapOp struct {
+	keep  Func
+	mask  Func
+	shift uint
+}
+
+var grayBits = [32]uint8{
+	0, 1, 0, 2, 0, 1, 0, 3,
+	0, 1, 0, 2, 0, 1, 0, 4,
+	0, 1, 0, 2, 0, 1, 0, 3,
+	0, 1, 0, 2, 0, 1, 0, 5,
+}
+
+var invertBits = [maxVar]invertOp{
+	{0x55555555, 1},
+	{0x33333333, 2},
+	{0x0f0f0f0f, 4},
+	{0x00ff00ff, 8},
+	{0x0000ffff, 16},
+}
+
+var swapBits = [maxVar - 1]swapOp{
+	{0x99999999, 0x22222222, 1},
+	{0xc3c3c3c3, 0x0c0c0c0c, 2},
+	{0xf00ff00f, 0x00f000f0, 4},
+	{0xff0000ff, 0x0000ff00, 8},
+}
+
+// newSpace returns the space for functions of n variables.
+// If npn is false, negating the output is not one of the
+// transformations relating the functions in a class.
+func newSpace(n int, npn bool) *space {
+	s := &space{numVar: n, numInput: 1 << n}
+	s.all = Func(uint64(1)<<s.numInput - 1)
+	s.top = uint(s.numInput - 1)
+	if npn {
+		s.neg = s.all
+	}
+	s.grayBit = append([]uint8(nil), grayBits[:s.numInput]...)
+	s.grayBit[s.numInput-1]-- // cycle back to start
+	for _, op := range invertBits[:n] {
+		s.invert = append(s.invert, invertOp{op.mask & s.all, op.shift})
+	}
+	for _, op := range swapBits[:n-1] {
+		s.swap = append(s.swap, swapOp{op.keep & s.all, op.mask & s.all, op.shift})
+	}
+	if n == 1 {
+		// Nothing to permute; use a single swap that changes nothing.
+		s.swap = []swapOp{{s.all, 0, 0}}
+		s.permuteBit = []int{0}
+	} else {
+		s.permuteBit = computePermuteBit(n)
+	}
+	return s
+}
+
+// format returns the hexadecimal form of f.
+func (s *space) format(f Func) string {
+	return fmt.Sprintf("%#0*x", (s.numInput+3)/4, uint32(f))
+}
+
+// literal returns the function whose value is always
+// equal to the literal input #i.
+func (s *space) literal(i int) Func {
+	f := Func(0)
+	for k := 0; k < s.numInput; k++ {
+		f |= Func((k>>uint(i))&1) << uint(k)
+	}
+	return f
+}
+
+// walk calls visit for each function obtained from f by negating
+// and permuting its inputs, along with the mask (0 or s.all)
+// that clears the top bit of that function, or 0 if negating
+// the output is not free. Each step applies the same
+// transformation to the functions in aux, which visit
+// may change; after the last call the transformations add up
+// to the identity, and walk returns the final values.
+// The calls cover each function in the class of f
+// whose top bit is clear (or each function in the class,
+// if negating the output is not free), as f' ^ mask, at least once.
+func (s *space) walk(f Func, aux []Func, visit func(f, mask Func)) {
+	f0 := f
+	for _, i := range s.grayBit {
+		m, sh := s.invert[i].mask, s.invert[i].shift
+		f = (f&m)<<sh | (f>>sh)&m
+		for k, x := range aux {
+			aux[k] = (x&m)<<sh | (x>>sh)&m
+		}
+		f1 := f
+		for _, j := range s.permuteBit {
+			k, m, sh := s.swap[j].keep, s.swap[j].mask, s.swap[j].shift
+			f = f&k | (f&m)<<sh | (f>>sh)&m
+			for k1, x := range aux {
+				aux[k1] = x&k | (x&m)<<sh | (x>>sh)&m
+			}
+			visit(f, -(f>>s.top)&s.neg)
+		}
+		if f != f1 {
+			panic("walk permute did not cycle")
+		}
+	}
+	if f != f0 {
+		panic("walk did not cycle")
+	}
+}
+
+// canon returns the canonical form of f: the smallest function
+// in its class.
+func (s *space) canon(f Func) Func {
+	minf := f
+	s.walk(f, nil, func(f, mask Func) {
+		if fc := f ^ mask; fc < minf {
+			minf = fc
+		}
+	})
+	return minf
+}
+
+// classSize returns the number of functions in the class of f.
+func (s *space) classSize(f Func) int {
+	// The class size is the group size divided by the size of
+	// the stabilizer of f, the transformations that leave f unchanged.
+	group := len(s.grayBit) * len(s.permuteBit)
+	stab := 0
+	s.walk(f, nil, func(g, mask Func) {
+		if g == f {
+			stab++
+		}
+		if s.neg != 0 && g^s.all == f {
+			stab++
+		}
+	})
+	if s.neg != 0 {
+		group *= 2
+	}
+	return group / stab
+}
+
+// fromCanon returns ops, which are given relative to c,
+// the canonical form of f, transformed by the input and output
+// negations and input permutation that map c to f.
+func (s *space) fromCanon(f, c Func, ops ...Func) []Func {
+	aux := make([]Func, len(ops))
+	s.walk(f, aux, func(f, mask Func) {
+		if f^mask == c {
+			// Record ops now: they match f.
+			// As walk cycles back to the original f,
+			// it keeps them in sync, so that at the end
+			// they will be the right ones for the original.
+			for i, x := range ops {
+				aux[i] = x ^ mask
+			}
+		}
+	})
+	return aux
+}
+
+// Generate permuteBit sequence for n.
+// Algorithm is from Knuth 7.2.1.2 Algorithm P (Plain changes).
+// 17th century bell ringing algorithm.
+func computePermuteBit(n int) []int {
+	var out []int
+	var j, s, q, x, y int
+
+	c := make([]int, n)
+	o := make([]int, n)
+	for i := range o {
+		o[i] = 1
+	}
+P2:
+	j = n
+	s = 0
+P4:
+	q = c[j-1] + o[j-1]
+	if q < 0 {
+		goto P7
+	}
+	if q == j {
+		goto P6
+	}
+	x, y = j-c[j-1]+s, j-q+s
+	if x < y {
+		out = append(out, x-1)
+	} else {
+		out = append(out, y-1)
+	}
+	c[j-1] = q
+	goto P2
+P6:
+	if j == 1 {
+		// Final swap to return to normal.
+		out = append(out, 0)
+		return out
+	}
+	s++
+P7:
+	o[j-1] = -o[j-1]
+	j--
+	goto P4
+}
+
+// A Space holds the functions of a given number of variables
+// and the transformations relating the functions in a class,
+// for packages that keep their own tables of classes,
+// like rsc.io/swtch/boolean-oracle/app/circuit.
+type Space struct {
+	s *space
+}
+
+// NewSpace returns the Space for functions of numVar variables.
+// If npn is false, negating the output is not one of the
+// transformations relating the functions in a class,
+// as in the NAND and NOR bases.
+func NewSpace(numVar int, npn bool) *Space {
+	return &Space{newSpace(numVar, npn)}
+}
+
+// All returns the function that is true for every input.
+func (s *Space) All() Func { return s.s.all }
+
+// NPN reports whether negating the output is one of the
+// transformations relating the functions in a class.
+func (s *Space) NPN() bool { return s.s.neg != 0 }
+
+// Format returns the hexadecimal form of f.
+func (s *Space) Format(f Func) string { return s.s.format(f) }
+
+// Canon returns the canonical form of f: the smallest function in its class.
+func (s *Space) Canon(f Func) Func { return s.s.canon(f) }
+
+// ClassSize returns the number of functions in the class of f.
+func (s *Space) ClassSize(f Func) int { return s.s.classSize(f) }
+
+// Transforms calls visit for each function g obtained from f
+// by negating and permuting its inputs, along with the
+// transformation: in g, input i is input perm[i] of f,
+// negated if bit i of neg is set: g(x) = f(y), where
+// y[perm[i]] = x[i] ^ neg[i]. Some functions may be visited
+// more than once. Visit must not retain perm.
+func (s *Space) Transforms(f Func, visit func(g Func, perm []int, neg int)) {
+	n := s.s.numVar
+	lits := make([]Func, n)
+	for i := range lits {
+		lits[i] = s.s.literal(i)
+	}
+	// Walk applies each transformation to the literals too:
+	// literal i becomes the function computing y[i] from x.
+	aux := append([]Func(nil), lits...)
+	perm := make([]int, n)
+	s.s.walk(f, aux, func(g, mask Func) {
+		neg := 0
+		for i, y := range aux {
+			for j, x := range lits {
+				if y == x || y == x^s.s.all {
+					perm[j] = i
+					if y != x {
+						neg |= 1 << uint(j)
+					}
+					break
+				}
+			}
+		}
+		visit(g, perm, neg)
+	})
+}
+
+// Project returns the variables that f, a function of numVar variables,
+// depends on, in order, and g, the function of n variables computed
+// by f with those variables renamed to the first ones,
+// or ok = false if f depends on more than n variables.
+func Project(f Func, numVar, n int) (vars []int, g Func, ok bool) {
+	s := newSpace(numVar, false)
+	for i := 0; i < numVar; i++ {
+		x := s.literal(i)
+		if (f&x)>>(1<<uint(i)) != f&^x {
+			vars = append(vars, i)
+		}
+	}
+	if len(vars) > n {
+		return nil, 0, false
+	}
+	for x := 0; x < 1<<uint(n); x++ {
+		y := 0
+		for i, v := range vars {
+			y |= (x >> uint(i) & 1) << uint(v)
+		}
+		g |= (f >> uint(y) & 1) << uint(x)
+	}
+	return vars, g, true
+}
+
+// unproject undoes Project: it returns the function of numVar variables
+// computed by g, a function of at least len(vars) variables that depends
+// only on the first len(vars), with variable i renamed to vars[i].
+func unproject(g Func, numVar int, vars []int) Func {
+	var f Func
+	for y := 0; y < 1<<uint(numVar); y++ {
+		x := 0
+		for i, v := range vars {
+			x |= (y >> uint(v) & 1) << uint(i)
+		}
+		f |= (g >> uint(x) & 1) << uint(y)
+	}
+	return f
+}
@@ -0,0 +1,500 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package table answers questions about minimal Boolean formulas
+// using the tables written by boolean-oracle/compute.
+//
+// A table records, for each NPN class of Boolean functions of
+// a given number of variables (the functions that are equivalent
+// under negating and permuting inputs and negating the output),
+// the canonical (smallest) function in the class, its minimal
+// formula size and the two (or, for a ternary operator, three)
+// functions it is computed from.
+// Formulas for other functions are derived from those of their classes.
+// In the NAND and NOR bases, in which negating a formula is not free,
+// and in tables in which NOT has a cost, the classes are NP classes,
+// which leave out negating the output.
+//
+// A table with costs (see checkpoint.Costs) records formulas
+// of minimal total cost instead of minimal size.
+//
+// A depth table records formulas of minimal depth instead,
+// and among those, of minimal size. Because the smallest formula
+// of a given depth may use operands that are not of minimal depth,
+// a depth table can have more than one entry for a class: one for
+// each depth at which its formulas get smaller
+// (see checkpoint.Table).
+package table
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"rsc.io/swtch/boolean-oracle/app/checkpoint"
+)
+
+// An Entry is the table entry for a class.
+type Entry struct {
+	F, P, Q, R Func // canonical function F, computed from P, Q and R
+	Size       int  // number of operators in a minimal formula for F
+	Cost       int  // total cost of the formula's operators; the same as Size with the default costs
+	Depth      int  // in a depth table, the depth of the formula; otherwise 0
+}
+
+// A Table is a table of minimal formulas.
+type Table struct {
+	checkpoint.Kind
+	Entries []Entry // sorted by F, and then by Depth
+
+	s *space
+}
+
+// New returns the table held in the checkpoint t.
+func New(t *checkpoint.Table) (*Table, error) {
+	if t.NumVar < 1 || t.NumVar > maxVar {
+		return nil, fmt.Errorf("table: invalid number of variables %d", t.NumVar)
+	}
+	if err := t.Kind.Check(); err != nil {
+		return nil, fmt.Errorf("table: %v", err)
+	}
+	if t.Metric == checkpoint.Circuit {
+		return nil, fmt.Errorf("table: %s is a circuit table", checkpoint.Name(t.Kind, t.Level()))
+	}
+	tab := &Table{
+		Kind:    t.Kind,
+		Entries: make([]Entry, 0, len(t.Howto)),
+		s:       newSpace(t.NumVar, t.Costs.NPN(t.Basis)),
+	}
+
+	// The level of an entry is its size in a size table,
+	// its depth in a depth table and its cost in a table with costs.
+	// In the last two, the size comes from the latest entries
+	// for the operands at lower levels.
+	depth := t.Metric == checkpoint.Depth
+	derive := depth || t.Costs != (checkpoint.Costs{})
+	latest := make(map[Func]int)
+	h := t.Howto
+	for level, n := range t.Counts {
+		start := len(tab.Entries)
+		for _, r := range h[:n] {
+			e := Entry{F: Func(r.F), P: Func(r.P), Q: Func(r.Q), R: Func(r.R), Size: level, Cost: level}
+			if derive {
+				e.Size = 0
+				if e.F != e.P {
+					e.Size = 1
+					for _, x := range tab.operands(&e) {
+						size, ok := latest[tab.Canon(x)]
+						if !ok {
+							return nil, fmt.Errorf("table: no entry at a level below %d for operand %s of %s", level, tab.Format(x), tab.Format(e.F))
+						}
+						e.Size += size
+					}
+				}
+			}
+			if depth {
+				e.Depth, e.Cost = level, e.Size
+			}
+			tab.Entries = append(tab.Entries, e)
+		}
+		if derive {
+			for _, e := range tab.Entries[start:] {
+				latest[e.F] = e.Size
+			}
+		}
+		h = h[n:]
+	}
+	sort.SliceStable(tab.Entries, func(i, j int) bool { return tab.Entries[i].F < tab.Entries[j].F })
+	return tab, nil
+}
+
+// operands returns the functions e.F is computed from:
+// e.P and e.Q, and e.R for a ternary operator, or only e.P for a NOT.
+func (t *Table) operands(e *Entry) []Func {
+	switch {
+	case e.R != 0:
+		return []Func{e.P, e.Q, e.R}
+	case t.isNot(e):
+		return []Func{e.P}
+	}
+	return []Func{e.P, e.Q}
+}
+
+// isNot reports whether e records a NOT,
+// which is an operator only in tables in which it has a cost.
+func (t *Table) isNot(e *Entry) bool {
+	return t.Costs.Not != 0 && e.Q == 0 && e.R == 0 && e.F == e.P^t.s.all
+}
+
+// OpCost returns the cost of op, an operator returned by Op,
+// using the table's costs. A literal costs 0.
+func (t *Table) OpCost(op string) int {
+	c := t.Costs.Effective()
+	switch op {
+	case "&":
+		return int(c.And)
+	case "|":
+		return int(c.Or)
+	case "^", "^^":
+		return int(c.Xor)
+	case "!":
+		return int(c.Not)
+	case "!&":
+		return int(c.Nand)
+	case "!|":
+		return int(c.Nor)
+	case "mux":
+		return int(c.Mux)
+	case "maj":
+		return int(c.Maj)
+	}
+	return 0
+}
+
+// Cost returns the total cost of the operators in x,
+// using the table's costs.
+func (t *Table) Cost(x *Tree) int {
+	n := t.OpCost(x.Op)
+	for _, y := range x.Args {
+		n += t.Cost(y)
+	}
+	return n
+}
+
+// All returns the function that is always true.
+func (t *Table) All() Func { return t.s.all }
+
+// Literal returns the function that is equal to input variable i.
+func (t *Table) Literal(i int) Func { return t.s.literal(i) }
+
+// Format returns the hexadecimal form of f,
+// with as many digits as the table's functions have.
+func (t *Table) Format(f Func) string { return t.s.format(f) }
+
+// Canon returns the canonical form of f: the smallest function in its class.
+func (t *Table) Canon(f Func) Func { return t.s.canon(f) }
+
+// ClassSize returns the number of functions in the class of f.
+func (t *Table) ClassSize(f Func) int { return t.s.classSize(f) }
+
+// Space returns the space of t's functions, whose classes are t's classes.
+func (t *Table) Space() *Space { return &Space{t.s} }
+
+// Lookup returns the entry for f, which must be canonical,
+// or nil if the table does not have one.
+// In a depth table, it returns the entry of minimal depth.
+func (t *Table) Lookup(f Func) *Entry {
+	i := sort.Search(len(t.Entries), func(i int) bool { return t.Entries[i].F >= f })
+	if i < len(t.Entries) && t.Entries[i].F == f {
+		return &t.Entries[i]
+	}
+	return nil
+}
+
+// lookupDepth returns the entry for f, which must be canonical,
+// with the greatest depth no more than depth, or nil if there is none.
+// In a size table, it is the same as Lookup.
+func (t *Table) lookupDepth(f Func, depth int) *Entry {
+	if t.Metric != checkpoint.Depth {
+		return t.Lookup(f)
+	}
+	i := sort.Search(len(t.Entries), func(i int) bool {
+		e := &t.Entries[i]
+		return e.F > f || e.F == f && e.Depth > depth
+	})
+	if i > 0 && t.Entries[i-1].F == f {
+		return &t.Entries[i-1]
+	}
+	return nil
+}
+
+// Op returns the operator that computes e.F from e.P, e.Q and e.R,
+// or "Lit" if e.F is a literal.
+// The binary operators are "&", "|", "^", "!&" (NAND), "!|" (NOR)
+// and, for the negation of XOR, "^^". They leave e.R zero.
+// The ternary operators are "mux" and "maj".
+// In a record for MUX, e.F is e.P ? e.Q : e.R or e.P ? e.R : e.Q.
+// In a table in which NOT has a cost, the unary operator "!"
+// is NOT, with only the operand e.P.
+// Op returns "?" if there is no such operator in the table's basis.
+func (t *Table) Op(e *Entry) string {
+	all := t.s.all
+	b := t.Basis
+	free := t.s.neg != 0
+	p, q, r := e.P, e.Q, e.R
+
+	// Different operators can compute F from the same operands,
+	// as x|!x is x^!x. The formula recorded uses the cheapest.
+	op := "?"
+	try := func(name string, ok bool) {
+		if ok && (op == "?" || t.OpCost(name) < t.OpCost(op)) {
+			op = name
+		}
+	}
+	switch {
+	case e.F == e.P:
+		return "Lit"
+	case t.isNot(e):
+		return "!"
+	case e.R != 0:
+		try("mux", b&checkpoint.Mux != 0 && (e.F == p&q|^p&r || e.F == p&r|^p&q))
+		try("maj", b&checkpoint.Maj != 0 && e.F == p&q|p&r|q&r)
+	case b&checkpoint.Nand != 0:
+		try("!&", e.F == (p&q)^all)
+	case b&checkpoint.Nor != 0:
+		try("!|", e.F == (p|q)^all)
+	default:
+		try("&", e.F == p&q)
+		try("|", e.F == p|q)
+		if b&checkpoint.Xor != 0 {
+			try("^", e.F == p^q)
+			try("^^", free && e.F == p^q^all)
+		}
+	}
+	return op
+}
+
+// A Tree is a formula.
+type Tree struct {
+	Op   string  // "Lit", "&", "|", "^", "!", "!&", "!|", "mux" or "maj"
+	F    Func    // function computed by the formula
+	Var  int     // for Op "Lit", the variable
+	Neg  bool    // for Op "Lit", whether the variable is negated
+	Args []*Tree // operands
+}
+
+// Tree returns a minimal formula for f.
+// In a depth table, it is a formula of minimal depth,
+// and the smallest of those.
+func (t *Table) Tree(f Func) (*Tree, error) {
+	c := t.Canon(f)
+	e := t.Lookup(c)
+	if e == nil {
+		return nil, fmt.Errorf("no table entry for %s", t.Format(c))
+	}
+	return t.tree(f, c, e)
+}
+
+// TreeAt returns the smallest formula for f of depth at most depth,
+// in a depth table. In a size table, it is the same as Tree.
+func (t *Table) TreeAt(f Func, depth int) (*Tree, error) {
+	c := t.Canon(f)
+	e := t.lookupDepth(c, depth)
+	if e == nil {
+		return nil, fmt.Errorf("no table entry for %s of depth at most %d", t.Format(c), depth)
+	}
+	return t.tree(f, c, e)
+}
+
+// TreeVars returns a minimal formula for f, a function of numVar variables,
+// which can be more than t.NumVar, provided f depends on at most t.NumVar
+// of them: the formula for f with those variables renamed to the first ones
+// (see Project), with the variables named back.
+func (t *Table) TreeVars(f Func, numVar int) (*Tree, error) {
+	if numVar == t.NumVar {
+		return t.Tree(f)
+	}
+	vars, g, ok := Project(f, numVar, t.NumVar)
+	if !ok {
+		return nil, fmt.Errorf("function depends on more than %d variables", t.NumVar)
+	}
+	x, err := t.Tree(g)
+	if err != nil {
+		return nil, err
+	}
+	x.rename(numVar, vars)
+	return x, nil
+}
+
+// rename turns x, a formula for a function that depends only on
+// the first len(vars) variables, into a formula of numVar variables,
+// renaming variable i to vars[i]. Only the formulas for the constants,
+// like v & !v, use variables that their functions do not depend on,
+// and for those, any variable will do.
+func (x *Tree) rename(numVar int, vars []int) {
+	x.F = unproject(x.F, numVar, vars)
+	if x.Op == "Lit" {
+		if x.Var < len(vars) {
+			x.Var = vars[x.Var]
+		} else {
+			x.Var = 0
+		}
+		s := newSpace(numVar, false)
+		if x.F = s.literal(x.Var); x.Neg {
+			x.F ^= s.all
+		}
+	}
+	for _, y := range x.Args {
+		y.rename(numVar, vars)
+	}
+}
+
+// tree returns the formula for f given by e,
+// the table entry for c, the canonical form of f.
+func (t *Table) tree(f, c Func, e *Entry) (*Tree, error) {
+	if e.F == e.P {
+		for i := 0; i < t.NumVar; i++ {
+			if x := t.Literal(i); f == x || f == x^t.s.all {
+				return &Tree{Op: "Lit", F: f, Var: i, Neg: f != x}, nil
+			}
+		}
+		return nil, fmt.Errorf("table entry for %s is not a literal", t.Format(c))
+	}
+
+	all := t.s.all
+	free := t.s.neg != 0
+	ops := t.s.fromCanon(f, c, e.P, e.Q, e.R)
+	p, q, r := ops[0], ops[1], ops[2]
+	var op string
+	var args []Func
+	switch t.Op(e) {
+	case "!":
+		op, args = "!", []Func{p}
+	case "mux":
+		switch {
+		case f == p&q|^p&r:
+			op, args = "mux", []Func{p, q, r}
+		case f == p&r|^p&q:
+			op, args = "mux", []Func{p, r, q}
+		}
+	case "maj":
+		if f == p&q|p&r|q&r {
+			op, args = "maj", []Func{p, q, r}
+		}
+	case "!&":
+		if f == (p&q)^all {
+			op, args = "!&", []Func{p, q}
+		}
+	case "!|":
+		if f == (p|q)^all {
+			op, args = "!|", []Func{p, q}
+		}
+	case "&", "|":
+		// With free negation, the transformation from the
+		// canonical function can turn AND into OR.
+		switch {
+		case f == p|q:
+			op, args = "|", []Func{p, q}
+		case f == p&q:
+			op, args = "&", []Func{p, q}
+		case free && f == p&(q^all):
+			op, args = "&", []Func{p, q ^ all}
+		case free && f == (p^all)&q:
+			op, args = "&", []Func{p ^ all, q}
+		}
+	case "^", "^^":
+		switch {
+		case f == p^q:
+			op, args = "^", []Func{p, q}
+		case free && f == p^q^all:
+			op, args = "^", []Func{p, q ^ all}
+		}
+	}
+	if op == "" {
+		return nil, fmt.Errorf("cannot determine operator for %s from %s, %s and %s", t.Format(f), t.Format(p), t.Format(q), t.Format(r))
+	}
+	x := &Tree{Op: op, F: f}
+	for _, a := range args {
+		// In a depth table, the operands are computed
+		// by formulas of lower depth than e's.
+		ca := t.Canon(a)
+		ea := t.lookupDepth(ca, e.Depth-1)
+		if ea == nil {
+			return nil, fmt.Errorf("no table entry for %s", t.Format(ca))
+		}
+		y, err := t.tree(a, ca, ea)
+		if err != nil {
+			return nil, err
+		}
+		x.Args = append(x.Args, y)
+	}
+	return x, nil
+}
+
+// varNames are the names of the variables in formulas.
+const varNames = "vwxyz"
+
+// String returns the formula, using v, w, x, y and z for the variables,
+// ! for negation, and parentheses where operators change.
+// A NOT of a subformula that is not a literal is written !(...).
+// NAND and NOR are written !& and !|, with parentheses around
+// every operand that is not a literal, and MUX and MAJ are written
+// as mux(s, a, b), for s ? a : b, and maj(a, b, c).
+func (t *Tree) String() string {
+	var b strings.Builder
+	t.format(&b, false)
+	return b.String()
+}
+
+// GroupedString is like String, but it puts parentheses around
+// every operand that is not a literal, even for a chain of the same
+// associative operator, so that the formula shows the shape of the tree
+// and so its depth.
+func (t *Tree) GroupedString() string {
+	var b strings.Builder
+	t.format(&b, true)
+	return b.String()
+}
+
+func (t *Tree) format(b *strings.Builder, grouped bool) {
+	switch t.Op {
+	case "Lit":
+		if t.Neg {
+			b.WriteByte('!')
+		}
+		b.WriteByte(varNames[t.Var])
+		return
+	case "!":
+		b.WriteString("!(")
+		t.Args[0].format(b, grouped)
+		b.WriteString(")")
+		return
+	case "mux", "maj":
+		b.WriteString(t.Op + "(")
+		for i, x := range t.Args {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			x.format(b, grouped)
+		}
+		b.WriteString(")")
+		return
+	}
+	assoc := t.Op != "!&" && t.Op != "!|"
+	for i, x := range t.Args {
+		if i > 0 {
+			b.WriteString(" " + t.Op + " ")
+		}
+		if x.Op != "Lit" && x.Op != "!" && x.Op != "mux" && x.Op != "maj" && (x.Op != t.Op || !assoc || grouped) {
+			b.WriteByte('(')
+			x.format(b, grouped)
+			b.WriteByte(')')
+		} else {
+			x.format(b, grouped)
+		}
+	}
+}
+
+// Size returns the number of operators in the formula.
+func (t *Tree) Size() int {
+	n := 0
+	if t.Op != "Lit" {
+		n = 1
+	}
+	for _, x := range t.Args {
+		n += x.Size()
+	}
+	return n
+}
+
+// Depth returns the depth of the formula: the largest number
+// of operators on a path from the top operator to a literal.
+func (t *Tree) Depth() int {
+	d := 0
+	for _, x := range t.Args {
+		if dx := x.Depth() + 1; dx > d {
+			d = dx
+		}
+	}
+	return d
+}
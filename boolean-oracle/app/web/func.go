@@ -4,15 +4,8 @@
 package web
 
 import (
-	"bufio"
 	"bytes"
-	"encoding/binary"
-	"encoding/gob"
 	"fmt"
-	"io"
-	"log"
-	"os"
-	"runtime"
 )
 
 // Change to compute different answer.
@@ -211,116 +204,4 @@ func findMin(f Func) Func {
 	return minf
 }
 
-// A Savepoint records information for starting again later.
-type Savepoint struct {
-	Howto  []Record
-	BySize [][]Func
-}
-
-func gobMarshal(name string, data interface{}) error {
-	f, err := os.Create(name)
-	if err != nil {
-		panic(err)
-	}
-	err = gob.NewEncoder(f).Encode(data)
-	if err != nil {
-		panic(err)
-	}
-	return nil
-}
-
 var blog bytes.Buffer
-
-func gobUnmarshal(name string, data interface{}) error {
-	var err error
-	var f io.Reader
-	f, err = os.Open(name)
-	if err != nil {
-		f1, err := os.Open(name + ".aa")
-		if err != nil {
-			return err
-		}
-		f2, err := os.Open(name + ".ab")
-		if err != nil {
-			return err
-		}
-		f = io.MultiReader(f1, f2)
-	}
-	err = gob.NewDecoder(f).Decode(data)
-	runtime.GC()
-	if err != nil {
-		panic(err)
-	}
-	return nil
-}
-
-type intwriter struct {
-	*bufio.Writer
-	buf [4]byte
-}
-
-func (w *intwriter) Write(x uint32) {
-	binary.BigEndian.PutUint32(w.buf[:], x)
-	w.Writer.Write(w.buf[:])
-}
-
-type intreader struct {
-	*bufio.Reader
-	buf [4]byte
-}
-
-func (r *intreader) Read() uint32 {
-	n, err := io.ReadFull(r.Reader, r.buf[:])
-	if n != 4 {
-		log.Fatal(err)
-	}
-	return binary.BigEndian.Uint32(r.buf[:])
-}
-
-func rawMarshal(name string, sp *Savepoint) {
-	f, err := os.Create(name)
-	if err != nil {
-		log.Fatal(err)
-	}
-	w := &intwriter{Writer: bufio.NewWriter(f)}
-	w.Write(uint32(len(sp.Howto)))
-	for _, r := range sp.Howto {
-		w.Write(uint32(r.F))
-		w.Write(uint32(r.P))
-		w.Write(uint32(r.Q))
-	}
-	w.Write(uint32(len(sp.BySize)))
-	for _, ff := range sp.BySize {
-		w.Write(uint32(len(ff)))
-		for _, f := range ff {
-			w.Write(uint32(f))
-		}
-	}
-	if err := w.Flush(); err != nil {
-		log.Fatal(err)
-	}
-}
-
-func rawUnmarshal(name string, sp *Savepoint) {
-	f, err := os.Open(name)
-	if err != nil {
-		log.Fatal(err)
-	}
-	r := &intreader{Reader: bufio.NewReader(f)}
-	sp.Howto = make([]Record, r.Read())
-	for i := range sp.Howto {
-		sp.Howto[i] = Record{
-			Func(r.Read()),
-			Func(r.Read()),
-			Func(r.Read()),
-		}
-	}
-	sp.BySize = make([][]Func, r.Read())
-	for i := range sp.BySize {
-		x := make([]Func, r.Read())
-		for j := range x {
-			x[j] = Func(r.Read())
-		}
-		sp.BySize[i] = x
-	}
-}
@@ -4,7 +4,6 @@
 package web
 
 import (
-	"bufio"
 	"bytes"
 	"fmt"
 	"html/template"
@@ -16,6 +15,10 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+
+	"rsc.io/swtch/boolean-oracle/app/checkpoint"
+	"rsc.io/swtch/boolean-oracle/app/circuit"
+	"rsc.io/swtch/boolean-oracle/app/table"
 )
 
 type Info struct {
@@ -36,13 +39,214 @@ func init() {
 	http.HandleFunc("/debug", debug)
 }
 
+// load loads the tables from the checkpoint files in $CHECKPOINT_DIR,
+// or else the current directory, setting fatalErr if they cannot be loaded.
 func load() {
-	info = loadInfo("a056287.5.28.raw")
-	xorInfo = loadInfo("xor.a056287.5.12.raw")
+	dir := os.Getenv("CHECKPOINT_DIR")
+	if dir == "" {
+		dir = "."
+	}
+	info, fatalErr = loadInfo(dir, 0, 28)
+	if fatalErr == nil {
+		xorInfo, fatalErr = loadInfo(dir, checkpoint.Xor, 12)
+	}
 }
 
 var once sync.Once
 
+// A Basis is one of the bases the user can choose,
+// in place of the default AND and OR (and XOR).
+// Its tables are loaded from the latest checkpoints
+// in the directory when first needed.
+type Basis struct {
+	Name  string // name in queries, as for checkpoint.ParseBasis
+	Title string // description of the operators
+
+	basis     checkpoint.Basis
+	mu        sync.Mutex
+	tables    map[checkpoint.Kind]*lazyTable
+	circuits  lazyCircuitTable
+	minimized map[table.Func]*lazyCircuit // by canonical function
+}
+
+// A lazyTable is a table loaded when first needed.
+type lazyTable struct {
+	once sync.Once
+	t    *table.Table
+	err  error
+}
+
+// andOr and andOrXor are the default bases. For the default
+// choice, their size tables are loaded by load, but their other
+// tables, like those of the other bases, are loaded when first needed.
+var (
+	andOr    = &Basis{Name: "and-or", Title: "AND and OR", basis: 0}
+	andOrXor = &Basis{Name: "xor", Title: "AND, OR, and XOR", basis: checkpoint.Xor}
+)
+
+var bases = []*Basis{
+	andOr,
+	andOrXor,
+	{Name: "nand", Title: "NAND", basis: checkpoint.Nand},
+	{Name: "nor", Title: "NOR", basis: checkpoint.Nor},
+	{Name: "mux", Title: "AND, OR, and MUX", basis: checkpoint.Mux},
+	{Name: "maj", Title: "AND, OR, and MAJ", basis: checkpoint.Maj},
+}
+
+// lookupBasis returns the Basis with the given name, or nil.
+func lookupBasis(name string) *Basis {
+	for _, b := range bases {
+		if b.Name == name {
+			return b
+		}
+	}
+	return nil
+}
+
+// table returns the table for b with the given metric and costs.
+func (b *Basis) table(metric checkpoint.Metric, costs checkpoint.Costs) (*table.Table, error) {
+	return b.tableVars(NumVar, metric, costs)
+}
+
+// smallVars is the number of variables in the tables used when
+// there is none for NumVar variables: compute can build depth tables
+// and tables for MUX and MAJ in practice only for up to 4 variables.
+const smallVars = 4
+
+// tableOrSmall returns the table for b with the given metric and costs,
+// for NumVar variables if there is one, or else for smallVars variables,
+// which has formulas only for the functions that depend on at most that many.
+func (b *Basis) tableOrSmall(metric checkpoint.Metric, costs checkpoint.Costs) (*table.Table, error) {
+	t, err := b.table(metric, costs)
+	if err == nil && t.NumVar == NumVar {
+		return t, nil
+	}
+	t, err1 := b.tableVars(smallVars, metric, costs)
+	if err1 != nil {
+		if err == nil {
+			err = fmt.Errorf("table has %d variables, want %d", t.NumVar, NumVar)
+		}
+		return nil, err
+	}
+	if t.NumVar != smallVars {
+		return nil, fmt.Errorf("table has %d variables, want %d or %d", t.NumVar, NumVar, smallVars)
+	}
+	return t, nil
+}
+
+// tableVars returns the table for b of functions of numVar variables
+// with the given metric and costs.
+func (b *Basis) tableVars(numVar int, metric checkpoint.Metric, costs checkpoint.Costs) (*table.Table, error) {
+	k := checkpoint.Kind{NumVar: numVar, Basis: b.basis, Metric: metric, Costs: costs}
+	b.mu.Lock()
+	lt := b.tables[k]
+	if lt == nil {
+		if b.tables == nil {
+			b.tables = make(map[checkpoint.Kind]*lazyTable)
+		}
+		lt = new(lazyTable)
+		b.tables[k] = lt
+	}
+	b.mu.Unlock()
+	lt.once.Do(func() {
+		dir := os.Getenv("CHECKPOINT_DIR")
+		if dir == "" {
+			dir = "."
+		}
+		ct, err := checkpoint.Latest(dir, k)
+		if err != nil {
+			lt.err = err
+			return
+		}
+		lt.t, lt.err = table.New(ct)
+	})
+	return lt.t, lt.err
+}
+
+// A lazyCircuit is a circuit found by circuit.Minimize when first needed.
+type lazyCircuit struct {
+	once sync.Once
+	c    *circuit.Circuit
+	err  error
+}
+
+// A lazyCircuitTable is a circuit table loaded when first needed.
+type lazyCircuitTable struct {
+	once sync.Once
+	t    *circuit.Table
+	err  error
+}
+
+// circuitVars is the number of variables in the circuit tables,
+// which compute can build only for up to 4 variables.
+const circuitVars = 4
+
+// circuitLimit is the number of gates circuit.Minimize tries
+// for a class of functions not in a circuit table, about a tenth
+// of a second's work.
+const circuitLimit = 1 << 18
+
+// maxMinimized is the number of classes for which each basis keeps
+// the circuit found by circuit.Minimize. When it has that many,
+// it drops them all and starts over.
+const maxMinimized = 1 << 12
+
+// circuit returns a circuit for f using b, from its circuit table
+// if it has one listing f, or else the smallest that circuit.Minimize
+// finds, starting from the minimal formula in its size table.
+// It runs circuit.Minimize once for each class of functions,
+// on the canonical function, and transforms that circuit into one for f.
+func (b *Basis) circuit(f Func) (*Circuit, error) {
+	lt := &b.circuits
+	lt.once.Do(func() {
+		dir := os.Getenv("CHECKPOINT_DIR")
+		if dir == "" {
+			dir = "."
+		}
+		k := checkpoint.Kind{NumVar: circuitVars, Basis: b.basis, Metric: checkpoint.Circuit}
+		ct, err := checkpoint.Latest(dir, k)
+		if err != nil {
+			lt.err = err
+			return
+		}
+		lt.t, lt.err = circuit.NewTable(ct)
+	})
+	lower := 0
+	if lt.t != nil {
+		if c := lt.t.Lookup(table.Func(f), NumVar); c != nil {
+			return &Circuit{c, b.Title}, nil
+		}
+		lower = lt.t.Lower(table.Func(f), NumVar)
+	}
+	t, err := b.table(checkpoint.Size, checkpoint.Costs{})
+	if err != nil {
+		return nil, err
+	}
+	canon := t.Canon(table.Func(f))
+	b.mu.Lock()
+	lc := b.minimized[canon]
+	if lc == nil {
+		if b.minimized == nil || len(b.minimized) >= maxMinimized {
+			b.minimized = make(map[table.Func]*lazyCircuit)
+		}
+		lc = new(lazyCircuit)
+		b.minimized[canon] = lc
+	}
+	b.mu.Unlock()
+	lc.once.Do(func() {
+		// The lower bound from the circuit table depends only on the class.
+		lc.c, lc.err = circuit.Minimize(t, canon, lower, circuitLimit)
+	})
+	if lc.err != nil {
+		return nil, lc.err
+	}
+	c := circuit.Transform(t.Space(), lc.c, table.Func(f))
+	if c == nil {
+		return nil, fmt.Errorf("circuit for %s does not transform", t.Space().Format(canon))
+	}
+	return &Circuit{c, b.Title}, nil
+}
+
 func debug(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.Write(blog.Bytes())
@@ -54,50 +258,21 @@ func (v byF) Len() int           { return len(v) }
 func (v byF) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
 func (v byF) Less(i, j int) bool { return v[i].F < v[j].F }
 
-func loadInfo(name string) []Info {
-	var state Savepoint
-	rawUnmarshal(name, &state)
-	info := make([]Info, len(state.Howto))
-	for i := range info {
-		info[i].Record = state.Howto[i]
+func loadInfo(dir string, basis checkpoint.Basis, level int) ([]Info, error) {
+	t, err := checkpoint.Load(dir, checkpoint.Kind{NumVar: NumVar, Basis: basis}, level)
+	if err != nil {
+		return nil, err
 	}
+	info := make([]Info, len(t.Howto))
 	n := 0
-	for size, ff := range state.BySize {
-		for _, f := range ff {
-			if info[n].F != f {
-				panic("inconsistent state")
-			}
-			info[n].Size = size
+	for size, c := range t.Counts {
+		for _, r := range t.Howto[n : n+c] {
+			info[n] = Info{Record{Func(r.F), Func(r.P), Func(r.Q)}, size}
 			n++
 		}
 	}
 	sort.Sort(byF(info))
-	return info
-}
-
-func dumpInfo(info []Info) {
-	w := bufio.NewWriter(os.Stdout)
-	for _, inf := range info {
-		var op string
-		switch {
-		case inf.F == inf.P:
-			fmt.Fprintf(w, "%v = literal (size %d)\n", inf.F, inf.Size)
-			continue
-		case inf.F == inf.P&inf.Q:
-			op = "&"
-		case inf.F == inf.P|inf.Q:
-			op = "|"
-		case inf.F == inf.P^inf.Q:
-			op = "^"
-		case inf.F == inf.P^inf.Q^(NumFunc-1):
-			op = "^^"
-		default:
-			fmt.Fprintf(os.Stderr, "unknown op: %v = %v ? %v\n", inf.F, inf.P, inf.Q)
-			op = "?"
-		}
-		fmt.Fprintf(w, "%v = %v %s %v (size %d)\n", inf.F, inf.P, op, inf.Q, inf.Size)
-	}
-	w.Flush()
+	return info, nil
 }
 
 var V = literal(0)
@@ -107,18 +282,41 @@ var Y = literal(3)
 var Z = literal(4)
 
 type MainData struct {
-	Query  string
-	Result template.HTML
-	Mem    uint64
+	Query   string
+	Costs   string
+	Circuit bool
+	Bases   []BasisOption
+	Result  template.HTML
+	Mem     uint64
+}
+
+// A BasisOption is a choice of basis on the main page.
+type BasisOption struct {
+	Name     string
+	Title    string
+	Selected bool
 }
 
 type ResultData struct {
-	Error   error
-	Query   string
-	Canon   Func
-	Func    Func
-	Tree    *Tree
-	XorTree *Tree
+	Error          error
+	Query          string
+	Canon          Func
+	Func           Func
+	Tree           *Tree
+	XorTree        *Tree
+	DepthTree      *Formula // formula of minimal depth using AND and OR, if known
+	XorDepthTree   *Formula // formula of minimal depth using AND, OR and XOR, if known
+	Basis          *Basis   // basis chosen by the user, if any
+	Costs          string   // costs of the operators chosen by the user, if any
+	BasisTree      *Formula // formula in that basis, with those costs
+	BasisDepthTree *Formula // formula of minimal depth in that basis, if known
+	Circuit        *Circuit // circuit using AND and OR, if asked for
+	XorCircuit     *Circuit // circuit using AND, OR and XOR, if asked for
+	BasisCircuit   *Circuit // circuit in the chosen basis, if asked for
+	DontCares      bool     // query specifies the function only where Care is set
+	Care           Func     // inputs on which the query specifies the function
+	Completion     Func     // function computed by the formulas, with don't-cares
+	XorCompletion  Func     // function computed by the formulas using XOR, with don't-cares
 }
 
 func run(w io.Writer, file string, data interface{}) {
@@ -155,9 +353,16 @@ func main(w http.ResponseWriter, req *http.Request) {
 	}()
 
 	q := req.FormValue("q")
-	data := &MainData{Query: q, Mem: 0}
+	basis := req.FormValue("basis")
+	costs := req.FormValue("costs")
+	circuit := req.FormValue("circuit") != ""
+	data := &MainData{Query: q, Costs: costs, Circuit: circuit, Mem: 0}
+	data.Bases = append(data.Bases, BasisOption{"", "AND, OR (and XOR)", basis == ""})
+	for _, b := range bases {
+		data.Bases = append(data.Bases, BasisOption{b.Name, b.Title, b.Name == basis})
+	}
 	if q != "" {
-		data.Result = template.HTML(result(q))
+		data.Result = template.HTML(result(q, basis, costs, circuit))
 	}
 	run(w, "main.html", data)
 }
@@ -165,27 +370,225 @@ func main(w http.ResponseWriter, req *http.Request) {
 func resultHandler(w http.ResponseWriter, req *http.Request) {
 	q := req.FormValue("q")
 	if q != "" {
-		w.Write(result(q))
+		w.Write(result(q, req.FormValue("basis"), req.FormValue("costs"), req.FormValue("circuit") != ""))
 	}
 }
 
-func result(q string) []byte {
+func result(q, basis, costs string, circuit bool) []byte {
 	var b bytes.Buffer
-	run(&b, "result.html", resultData(q))
+	run(&b, "result.html", resultData(q, basis, costs, circuit))
 	return b.Bytes()
 }
 
-func resultData(q string) (res ResultData) {
+// resultData returns the data for the result of query q.
+// If basis is not empty, the result is a formula in that basis,
+// of minimal total cost if costs gives the costs of the operators
+// (as for checkpoint.ParseCosts); otherwise it is formulas using
+// AND and OR, and using AND, OR and XOR.
+// Each formula of minimal size comes with one of minimal depth
+// (and of minimal size for that depth), if there is a depth table
+// for the basis that includes the function.
+// If circuit is set, each also comes with a circuit, in which
+// gates can share results, found by Basis.circuit.
+// If q has don't-cares (see parseQuery), the results for each basis
+// are for the completion of q with the cheapest formula in that basis.
+func resultData(q, basis, costs string, circuit bool) (res ResultData) {
 	once.Do(load)
 	res.Query = q
+	if fatalErr != nil {
+		res.Error = fatalErr
+		return
+	}
+	if basis != "" {
+		res.Basis = lookupBasis(basis)
+		if res.Basis == nil {
+			res.Error = fmt.Errorf("Unknown basis %q", basis)
+			return
+		}
+	}
+	c, err := checkpoint.ParseCosts(costs)
+	if err == nil && res.Basis == nil && c != (checkpoint.Costs{}) {
+		err = fmt.Errorf("choose a basis")
+	}
+	if err == nil && res.Basis != nil {
+		err = c.Check(res.Basis.basis)
+	}
+	if err != nil {
+		res.Error = fmt.Errorf("Invalid costs: %v", err)
+		return
+	}
+	if circuit && c != (checkpoint.Costs{}) {
+		res.Error = fmt.Errorf("Circuits have only the default costs")
+		return
+	}
+	if circuit && res.Basis != nil && res.Basis.basis.Ternary() {
+		res.Error = fmt.Errorf("No circuits using %s: circuits have only binary operators", res.Basis.Title)
+		return
+	}
+	if c != (checkpoint.Costs{}) {
+		res.Costs = c.String()
+	}
+	fb, care, err := parseQuery(q)
+	if err != nil {
+		res.Error = err
+		return
+	}
+	if care != NumFunc-1 {
+		res.DontCares = true
+		res.Care = care
+		fb &= care
+	}
+	res.Func = fb
+	res.Canon = findMin(fb)
+	if res.Basis != nil {
+		t, err := res.Basis.tableOrSmall(checkpoint.Size, c)
+		if err != nil {
+			if res.Costs != "" {
+				res.Error = fmt.Errorf("No table for %s with costs %s: %v", res.Basis.Title, res.Costs, err)
+			} else {
+				res.Error = fmt.Errorf("No table for %s: %v", res.Basis.Title, err)
+			}
+			return
+		}
+		if res.DontCares {
+			if t.NumVar != NumVar {
+				res.Error = fmt.Errorf("No formula using %s: don't-cares need a table for %d variables", res.Basis.Title, NumVar)
+				return
+			}
+			g, err := t.Complete(table.Func(fb), table.Func(care))
+			if err != nil {
+				res.Error = fmt.Errorf("No formula using %s: %v", res.Basis.Title, err)
+				return
+			}
+			fb = Func(g)
+			res.Completion = fb
+		}
+		if t.NumVar == NumVar {
+			res.Canon = Func(t.Canon(table.Func(fb)))
+		} else {
+			res.Canon = Func(table.NewSpace(NumVar, res.Basis.basis.NPN()).Canon(table.Func(fb)))
+		}
+		tree, err := t.TreeVars(table.Func(fb), NumVar)
+		if err != nil {
+			if t.NumVar != NumVar {
+				err = fmt.Errorf("No formula using %s: %v", res.Basis.Title, err)
+			}
+			res.Error = err
+			return
+		}
+		res.BasisTree = &Formula{Tree: tree, cost: t.Cost(tree)}
+		if res.Costs == "" {
+			// Depth tables have only the default costs.
+			res.BasisDepthTree = depthFormula(res.Basis, fb)
+		}
+		if circuit {
+			res.BasisCircuit, res.Error = res.Basis.circuit(fb)
+		}
+		return
+	}
+	// With don't-cares, each basis has its own cheapest completion.
+	xfb := fb
+	if res.DontCares {
+		if fb, err = complete(andOr, fb, care); err == nil {
+			xfb, err = complete(andOrXor, xfb, care)
+		}
+		if err != nil {
+			res.Error = err
+			return
+		}
+		res.Completion, res.XorCompletion = fb, xfb
+	}
+	res.Tree = findTree(fb, info)
+	res.XorTree = findTree(xfb, xorInfo)
+	res.DepthTree = depthFormula(andOr, fb)
+	res.XorDepthTree = depthFormula(andOrXor, xfb)
+	if circuit {
+		if res.Circuit, err = andOr.circuit(fb); err == nil {
+			res.XorCircuit, err = andOrXor.circuit(xfb)
+		}
+		res.Error = err
+	}
+	return
+}
+
+// complete returns the completion of f, which is specified
+// where care is set, with the smallest formula using b.
+func complete(b *Basis, f, care Func) (Func, error) {
+	t, err := b.table(checkpoint.Size, checkpoint.Costs{})
+	if err != nil {
+		return 0, fmt.Errorf("No table for %s: %v", b.Title, err)
+	}
+	if t.NumVar != NumVar {
+		return 0, fmt.Errorf("Table for %s has %d variables, want %d", b.Title, t.NumVar, NumVar)
+	}
+	g, err := t.Complete(table.Func(f), table.Func(care))
+	if err != nil {
+		return 0, fmt.Errorf("No formula using %s: %v", b.Title, err)
+	}
+	return Func(g), nil
+}
+
+// parseQuery returns the function f given by the query q
+// and the inputs on which it is specified, those set in care.
+// The query is an expression or truth table, as for evalQuery,
+// or a truth table with a '-' for each input on which f is not
+// specified, optionally followed by "where" and an expression
+// or truth table giving the inputs on which f is specified.
+func parseQuery(q string) (f, care Func, err error) {
+	care = NumFunc - 1
+	if i := strings.Index(q, " where "); i >= 0 {
+		if care, err = evalQuery(q[i+len(" where "):]); err != nil {
+			return 0, 0, err
+		}
+		q = q[:i]
+	}
+	if tt, tcare, ok := parseDontCares(q); ok {
+		return tt, care & tcare, nil
+	}
+	f, err = evalQuery(q)
+	return f, care, err
+}
+
+// parseDontCares parses s as a truth table written in binary,
+// with NumInput digits, optionally preceded by 0b, that has a '-'
+// for each input on which the function is not specified.
+// As in the hexadecimal truth tables, the last digit is the
+// value for input 0. It returns the function, with 0 for the
+// inputs not specified, and the inputs specified.
+// If s is not such a truth table, parseDontCares returns ok = false.
+func parseDontCares(s string) (f, care Func, ok bool) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(s, "0b")
+	if len(s) != NumInput || !strings.Contains(s, "-") {
+		return 0, 0, false
+	}
+	for i := 0; i < NumInput; i++ {
+		bit := Func(1) << uint(NumInput-1-i)
+		switch s[i] {
+		default:
+			return 0, 0, false
+		case '0':
+			care |= bit
+		case '1':
+			f |= bit
+			care |= bit
+		case '-':
+		}
+	}
+	return f, care, true
+}
+
+// evalQuery returns the function given by the query q:
+// the truth table given by a number, or else the function
+// computed by an expression over the variables.
+func evalQuery(q string) (Func, error) {
 	var fb Func
 	if n, err := strconv.ParseUint(q, 0, 64); err == nil {
 		fb = Func(n)
 	} else {
 		f, err := parse(q)
 		if err != nil {
-			res.Error = fmt.Errorf("Error parsing query: %s", err)
-			return
+			return 0, fmt.Errorf("Error parsing query: %s", err)
 		}
 
 		fp := func(val []int) int {
@@ -205,15 +608,86 @@ func resultData(q string) (res ResultData) {
 			}
 		}
 	}
-	res.Func = fb
-	res.Canon = findMin(fb)
-	res.Tree = findTree(fb, info)
-	res.XorTree = findTree(fb, xorInfo)
-	return
+	return fb, nil
+}
+
+// depthFormula returns a formula of minimal depth for f using basis b,
+// or nil if there is no depth table for b or it has no entry for f,
+// as when the table stops before the depth of f.
+// Without a depth table for NumVar variables, it uses the one
+// for smallVars variables, for functions that depend on at most that many.
+func depthFormula(b *Basis, f Func) *Formula {
+	t, err := b.tableOrSmall(checkpoint.Depth, checkpoint.Costs{})
+	if err != nil {
+		return nil
+	}
+	tree, err := t.TreeVars(table.Func(f), NumVar)
+	if err != nil {
+		return nil
+	}
+	return &Formula{Tree: tree, cost: t.Cost(tree), grouped: true}
+}
+
+// A Formula is a formula found using package table.
+type Formula struct {
+	*table.Tree
+	cost    int  // total cost of its operators, using the table's costs
+	grouped bool // show the grouping of every operator, for a depth formula
+}
+
+// Cost returns the total cost of the formula's operators.
+func (f *Formula) Cost() int {
+	return f.cost
+}
+
+func (f *Formula) HTML() template.HTML {
+	s := f.String()
+	if f.grouped {
+		s = f.GroupedString()
+	}
+	s = strings.Replace(s, " !& ", " ↑ ", -1)
+	s = strings.Replace(s, " !| ", " ↓ ", -1)
+	return formulaHTML(s)
+}
+
+func (f *Formula) Complexity() string {
+	return complexity(f.Size())
+}
+
+// A Circuit is a circuit found using package circuit.
+type Circuit struct {
+	*circuit.Circuit
+	Title string // description of the operators of its basis
+}
+
+// Gates returns the number of gates, as "1 gate" or "N gates".
+func (c *Circuit) Gates() string {
+	if c.Size() == 1 {
+		return "1 gate"
+	}
+	return fmt.Sprintf("%d gates", c.Size())
+}
+
+// HTML returns the numbered gate list, one gate to a line,
+// with arrows for NAND and NOR and overlines for negations.
+func (c *Circuit) HTML() template.HTML {
+	var lines []string
+	for _, line := range c.Lines() {
+		line = strings.Replace(line, " !& ", " ↑ ", -1)
+		line = strings.Replace(line, " !| ", " ↓ ", -1)
+		lines = append(lines, overline(esc(line)))
+	}
+	return template.HTML(strings.Join(lines, "<br>\n"))
 }
 
 func (t *Tree) HTML() template.HTML {
-	s := t.String()
+	return formulaHTML(t.String())
+}
+
+// formulaHTML returns the HTML for the formula s,
+// with larger parentheses for the outer levels
+// and overlines for negations.
+func formulaHTML(s string) template.HTML {
 	s = strings.Replace(s, "(", `«`, -1)
 	s = strings.Replace(s, ")", `»`, -1)
 	s = replaceBracket(s, "(", ")")
@@ -325,8 +799,24 @@ func (t *Tree) complexity() int {
 	return t.L.complexity() + 1 + t.R.complexity()
 }
 
+// Depth returns the depth of the formula: the largest number
+// of operators on a path from the top operator to a literal.
+func (t *Tree) Depth() int {
+	if t.Op == "Lit" {
+		return 0
+	}
+	l, r := t.L.Depth(), t.R.Depth()
+	if l < r {
+		l = r
+	}
+	return l + 1
+}
+
 func (t *Tree) Complexity() string {
-	n := t.complexity()
+	return complexity(t.complexity())
+}
+
+func complexity(n int) string {
 	if n == 1 {
 		return "1 operator"
 	}
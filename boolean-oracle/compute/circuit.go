@@ -0,0 +1,131 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// See comment at top of compute.go for information about how to run.
+
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"rsc.io/swtch/boolean-oracle/app/checkpoint"
+	"rsc.io/swtch/boolean-oracle/app/circuit"
+	"rsc.io/swtch/boolean-oracle/app/table"
+)
+
+// With -circuit, compute builds a circuit table (see checkpoint.Table),
+// in which level g lists the classes whose minimal circuits have g gates.
+//
+// Unlike formulas, circuits cannot be built from the minimal circuits
+// of smaller functions, because a gate's result can be used more than
+// once, so compute runs circuit.Find for each class not yet found,
+// asking for a circuit with at most g gates at level g.
+// The classes are independent, so the workers split them up, and the
+// circuits are recorded in class order, so the tables written do not
+// depend on the number of workers.
+
+// exploreCircuits computes the circuit table,
+// starting after the latest checkpoint.
+func exploreCircuits() {
+	var circuits []*circuit.Circuit
+	level := 0
+	ct, err := checkpoint.Latest(*dir, kind())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("ignoring checkpoint: %v", err)
+	}
+	if err == nil {
+		t, err := circuit.NewTable(ct)
+		if err != nil {
+			log.Fatal(err)
+		}
+		circuits = t.Circuits
+		level = t.Level()
+	}
+
+	// The classes, and those with circuits so far.
+	done := make(map[Func]bool)
+	for _, c := range circuits {
+		done[canonRecord(Record{F: Func(c.F)}).F] = true
+	}
+	var classes []Func
+	for f := uint64(0); f <= uint64(allFunc); f++ {
+		if canonRecord(Record{F: Func(f)}).F == Func(f) && !done[Func(f)] {
+			classes = append(classes, Func(f))
+		}
+	}
+	log.Println(level, len(circuits), len(classes))
+
+	if len(circuits) == 0 {
+		// Level 0 holds the only literal class.
+		// Find returns a literal's circuit for any max.
+		f := canonRecord(Record{F: literal(0)}).F
+		circuits = append(circuits, circuit.Find(NumVar, basis, table.Func(f), 0))
+		classes = remove(classes, f)
+		if err := checkpoint.Write(*dir, circuitSavepoint(circuits, 0)); err != nil {
+			log.Printf("writing checkpoint: %v", err)
+		}
+		log.Println(0, 1, len(classes))
+	}
+
+	for level++; len(classes) > 0; level++ {
+		t0 := time.Now()
+		found := make([]*circuit.Circuit, len(classes))
+		var wg sync.WaitGroup
+		sema := make(chan bool, *procs)
+		for i, f := range classes {
+			wg.Add(1)
+			sema <- true
+			go func(i int, f Func) {
+				defer wg.Done()
+				found[i] = circuit.Find(NumVar, basis, table.Func(f), level)
+				<-sema
+			}(i, f)
+		}
+		wg.Wait()
+		var rest []Func
+		n := 0
+		for i, c := range found {
+			if c == nil {
+				rest = append(rest, classes[i])
+				continue
+			}
+			if c.Size() != level {
+				log.Fatalf("class %v has a circuit with %d gates at level %d", classes[i], c.Size(), level)
+			}
+			circuits = append(circuits, c)
+			n++
+		}
+		classes = rest
+		log.Println(level, n, len(classes), time.Since(t0).Seconds())
+
+		if err := checkpoint.Write(*dir, circuitSavepoint(circuits, level)); err != nil {
+			log.Printf("writing checkpoint: %v", err)
+		}
+	}
+}
+
+// remove returns fs with f removed.
+func remove(fs []Func, f Func) []Func {
+	for i, g := range fs {
+		if g == f {
+			return append(fs[:i], fs[i+1:]...)
+		}
+	}
+	return fs
+}
+
+// circuitSavepoint returns the checkpoint table through the given level
+// for the circuits found so far, listed in order of size.
+func circuitSavepoint(circuits []*circuit.Circuit, level int) *checkpoint.Table {
+	t := &checkpoint.Table{Kind: kind(), Counts: make([]int, level+1)}
+	for _, c := range circuits {
+		recs := circuit.Records(c)
+		t.Counts[c.Size()] += len(recs)
+		t.Howto = append(t.Howto, recs...)
+	}
+	return t
+}
@@ -1,27 +1,79 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// Run with "go build compute.go func.go; ./compute".
-// Edit func.go's NumVar to change the value being computed.
-// (Having a compile time constant makes the compiled code more efficient.)
+// Run with "go build; ./compute -n 4".
+// The -n flag sets the number of variables, from 1 to 5.
 //
-// Writes checkpointed state to files in /tmp named /tmp/a056287.N.M.gob.
-// Each is the functions of complexity M over N variables.
-// Right now there's no easy way to get text output.
+// The work for each level is split across -procs goroutines.
+// The result does not depend on the number used: -procs=1
+// produces the same checkpoint files as any other setting.
+//
+// The -basis flag sets the operators allowed in formulas:
+// and-or (the default), nand (NAND only), nor (NOR only),
+// or AND and OR plus any of xor, mux and maj, as in -basis=mux,xor.
+// The -xor flag is short for adding xor.
+// The ternary operators mux and maj are practical only for
+// up to 4 variables.
+//
+// The -depth flag computes formulas of minimal depth, and among those,
+// minimal size, instead of formulas of minimal size; see depth.go.
+// It is practical only for up to 4 variables, and it does not
+// support mux and maj.
+//
+// The -costs flag gives operators costs other than the default of 1,
+// as in -costs=xor=2,not=1, and compute finds formulas of minimal
+// total cost instead of minimal size; see checkpoint.Costs.
+// By default, negation is free; giving NOT a cost makes it an operator
+// on subformulas, while negating inputs stays free.
+// The levels are then costs, and the formulas of cost M are built
+// from operands whose costs add up to M minus the operator's cost.
+// Each function's cost takes 8 bits in the size table instead of 5,
+// so -costs needs more memory; with a cost for NOT, which doubles
+// the number of classes, it is practical only for up to 4 variables.
+// It does not support -depth.
+//
+// The -circuit flag computes minimal circuits, in which, unlike in
+// formulas, the result of a gate can be used more than once;
+// see circuit.go. It is practical only for up to 4 variables,
+// and it does not support mux, maj, -depth or -costs.
+// For 4 variables, each level takes about 30 times as long as
+// the one before, so with and-or, nand and nor, the last levels
+// take days, even split across many -procs.
+//
+// Writes checkpointed state to files in the -dir directory (default /tmp)
+// named a056287.N.M.ckpt, or xor.a056287.N.M.ckpt with -xor,
+// nand.a056287.N.M.ckpt with -basis=nand, and so on,
+// with a "depth." prefix for -depth, a "circuit." prefix for -circuit,
+// and the costs after the basis for -costs, as in xor.xor2.not1.a056287.N.M.ckpt.
+// Each is the functions of complexity M (or depth M, or cost M,
+// or M gates) over N variables.
+// See rsc.io/swtch/boolean-oracle/app/checkpoint for the format.
+// Use boolean-oracle/export to print them as text.
 
 package main
 
 import (
+	"errors"
 	"flag"
-	"fmt"
 	"log"
+	"os"
 	"runtime"
+	"sort"
+	"sync/atomic"
 	"time"
+
+	"rsc.io/swtch/boolean-oracle/app/checkpoint"
 )
 
 var redo = flag.Int("redo", -1, "level to redo")
 var xor = flag.Bool("xor", false, "allow xor")
-var cutoff = flag.Int("cutoff", 30, "last level for explore algorithm")
+var basisFlag = flag.String("basis", "and-or", "operators to allow: and-or, nand, nor, or a list of xor, mux and maj")
+var cutoff = flag.Int("cutoff", 30, "last level for explore algorithm (ignored except with and-or and xor)")
+var nvar = flag.Int("n", 4, "number of variables (1-5)")
+var depth = flag.Bool("depth", false, "minimize depth, and then size")
+var circuitFlag = flag.Bool("circuit", false, "find minimal circuits instead of formulas")
+var costsFlag = flag.String("costs", "", "costs of operators, as in xor=2,not=1 (default 1 each, with free negation)")
+var dir = flag.String("dir", "/tmp", "directory for checkpoint files")
 
 // A Queue is a queue of Boolean functions that we found.
 type Queue []Func
@@ -34,21 +86,85 @@ func (q *Queue) take() []Func {
 }
 
 var howto []Record
-var xorprefix = ""
+var basis checkpoint.Basis
+var metric checkpoint.Metric
+var costs checkpoint.Costs
+
+// kind returns the kind of table being computed.
+func kind() checkpoint.Kind {
+	return checkpoint.Kind{NumVar: NumVar, Basis: basis, Metric: metric, Costs: costs}
+}
 
 func main() {
 	flag.Parse()
 
+	if *nvar < 1 || *nvar > MaxVar {
+		log.Fatalf("-n must be between 1 and %d", MaxVar)
+	}
+	b, err := checkpoint.ParseBasis(*basisFlag)
+	if err != nil {
+		log.Fatalf("-basis: %v", err)
+	}
+	basis = b
 	if *xor {
-		xorprefix = "xor."
+		basis |= checkpoint.Xor
+	}
+	if err := basis.Check(); err != nil {
+		log.Fatal(err)
+	}
+	c, err := checkpoint.ParseCosts(*costsFlag)
+	if err != nil {
+		log.Fatalf("-costs: %v", err)
+	}
+	costs = c
+	if err := costs.Check(basis); err != nil {
+		log.Fatal(err)
+	}
+	if *depth {
+		if basis.Ternary() {
+			log.Fatal("-depth does not support mux and maj")
+		}
+		if costs != (checkpoint.Costs{}) {
+			log.Fatal("-depth does not support -costs")
+		}
+		metric = checkpoint.Depth
+	}
+	if *circuitFlag {
+		if *nvar > 4 {
+			log.Fatal("-circuit supports at most 4 variables")
+		}
+		if basis.Ternary() {
+			log.Fatal("-circuit does not support mux and maj")
+		}
+		if costs != (checkpoint.Costs{}) {
+			log.Fatal("-circuit does not support -costs")
+		}
+		if *depth {
+			log.Fatal("-circuit does not support -depth")
+		}
+		metric = checkpoint.Circuit
+	}
+	setNumVar(*nvar)
+	setOps()
+
+	if metric == checkpoint.Circuit {
+		exploreCircuits()
+		return
 	}
 
+	// The search algorithm knows only AND, OR and XOR, of cost 1.
+	canSearch := basis&^checkpoint.Xor == 0 && costs == (checkpoint.Costs{})
+
 	// Queue of all functions to consider.
-	q := make(Queue, 0, maxFunc[NumVar])
-	howto = make([]Record, 0, maxFunc[NumVar])
+	nclass := maxFunc[NumVar]
+	if !costs.NPN(basis) {
+		nclass *= 2
+	}
+	q := make(Queue, 0, nclass)
+	howto = make([]Record, 0, nclass)
 
-	// Functions in queue indexed by size.
-	bySize := make([][]Func, 100)
+	// Functions in queue indexed by size (or cost).
+	bySize := make([][]Func, sizeMax+1)
 
 	// Initialize size bits to all 1s to mean unknown.
 	for i := range size {
@@ -57,23 +173,32 @@ func main() {
 
 	// Try to pick up where we left off.
 	var targ int
-	for targ = 30; targ > 0; targ-- {
-		var state Savepoint
-		name := fmt.Sprintf("/tmp/%sa056287.%d.%d.gob", xorprefix, NumVar, targ)
-		if err := gobUnmarshal(name, &state); err != nil {
+	for targ = len(bySize) - 1; targ > 0; targ-- {
+		state, err := checkpoint.Load(*dir, kind(), targ)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				log.Printf("ignoring checkpoint: %v", err)
+			}
 			continue
 		}
 		h := state.Howto
 		nh := 0
-		for i, b := range state.BySize {
+		for i, n := range state.Counts {
 			if i == *redo {
 				targ = *redo - 1
 				break
 			}
-			for _, h := range h[nh : nh+len(b)] {
-				q.visit(h.F, h.P, h.Q, i)
+			for _, h := range h[nh : nh+n] {
+				if metric == checkpoint.Depth && i > 0 {
+					q.improve(Record{Func(h.F), Func(h.P), Func(h.Q), Func(h.R)})
+				} else {
+					q.visit(Func(h.F), Func(h.P), Func(h.Q), Func(h.R), i)
+				}
+			}
+			if metric == checkpoint.Depth {
+				q.commit()
 			}
-			nh += len(b)
+			nh += n
 			bySize[i] = q.take()
 			log.Println(i, len(bySize[i]), nvisited, cap(bySize[0])-cap(q), len(howto))
 		}
@@ -83,100 +208,153 @@ func main() {
 	if targ == 0 {
 		// Build and queue functions of complexity 0.
 		// There's only one (all the others are equivalent).
-		q.visit(literal(0)^(NumFunc-1), literal(0)^(NumFunc-1), 0, 0)
+		q.visit(literal(0)^allFunc, literal(0)^allFunc, 0, 0, 0)
 		bySize[0] = q.take()
-		if nvisited != 2*NumVar {
+		if nvisited != 2*uint64(NumVar) {
 			panic("wrong visit count after literal")
 		}
 		log.Println(0, len(bySize[0]), nvisited, cap(bySize[0])-cap(q), len(howto))
 	}
 
+	if metric == checkpoint.Depth {
+		q.exploreDepth(bySize, targ)
+		return
+	}
+
 	for targ++; nvisited < NumFunc; targ++ {
+		if targ > sizeMax {
+			log.Fatalf("level %d is too large for the size table", targ)
+		}
 		runtime.GC()
 		var t0, t1 time.Time
-		if targ <= *cutoff {
+		if targ <= *cutoff || !canSearch {
 			// Build functions of higher complexity from lower ones.
-			resetDid()
 			t0 = time.Now()
-			for i := 0; i+i+1 < targ; i++ {
-				fs := bySize[i]
-				gs := bySize[targ-1-i]
-				for _, f := range fs {
-					q.explore(f, gs, targ)
-				}
+			tasks := appendBinaryTasks(nil, bySize, targ)
+			if basis.Ternary() {
+				tasks = appendTernaryTasks(tasks, bySize, targ)
 			}
-			if (targ-1)%2 == 0 {
-				i := targ / 2
-				gs := bySize[i]
-				for j, f := range gs {
-					q.explore(f, gs[:j+1], targ)
-				}
+			if c := int(costs.Not); c != 0 && c <= targ {
+				tasks = append(tasks, exploreTask{gs: bySize[targ-c], op: negation})
 			}
+			q.exploreAll(tasks, targ)
 			t1 = time.Now()
 		} else {
 			// Near end.  Search for ways to create missing functions.
 			missing := NumFunc - nvisited
 			t0 = time.Now()
-			total0, total1, total2 := q.searchRange(0, NumFunc/2, bySize, targ)
+			total0, total1, total2 := q.searchAll(bySize, targ)
 			t1 = time.Now()
 			log.Println("search", targ, missing, NumFunc-nvisited, total0, total1, total2)
 		}
 		bySize[targ] = q.take()
 		log.Println(targ, len(bySize[targ]), nvisited, cap(bySize[0])-cap(q), len(howto), t1.Sub(t0).Seconds())
 
-		var state Savepoint
-		state.Howto = howto
-		state.BySize = bySize[0 : targ+1]
-		name := fmt.Sprintf("/tmp/%sa056287.%d.%d.gob", xorprefix, NumVar, targ)
-		err := gobMarshal(name, &state)
-		if err != nil {
-			log.Printf("gob marshal: %s", err)
+		if err := checkpoint.Write(*dir, savepoint(bySize[0:targ+1])); err != nil {
+			log.Printf("writing checkpoint: %v", err)
 		}
 	}
 }
 
 // visited holds the bitmap of which functions we've visited.
-// We can arrange to clear the top bit of any func without
-// loss of generality, hence the extra factor of two.
-var visited [(NumFunc/2 + 64 - 1) / 64]uint64
-var nvisited uint64 // number of bits set
+// When negation is free, we can arrange to clear the top bit
+// of any func without loss of generality, hence the extra factor of two.
+// It is allocated by setNumVar.
+var visited []uint64
+var nvisited uint64 // number of functions visited
 
 // size gives the number of variables needed to compute
-// the function f.  It packs the 5-bit sizes of 12 functions into
-// a single 64-bit word.  (The last 4 bits in each word are wasted.)
-var size [(NumFunc/2 + 11) / 12]uint64
-
-// did is a probabilistic data structure for tracking which
-// functions f have already been explored.  The exploration
-// of f begins by setting did[f%len(f)] = f, so if did[f%len(f)]==f,
-// then f has been explored.  Because multiple f hash to the
-// same array index, did[f%len(f)] != f does not guarantee that
-// f is unexplored, but the possibility is rare and the duplicated
-// effort harmless if inefficient.
-//
-// The probabilistic check gives O(1) lookup and O(1) insertion times,
-// in contrast to the larger times for a precise sorted or unsorted list,
-// and it is utterly trivial to implement.
-//
-var did [100003]Func
+// the function f, or with -costs, the cost.  It packs the sizes
+// of several functions into each 64-bit word, as described at sizeBits.
+// It is allocated by setNumVar.
+var size []uint64
+
+// opsCost is a set of binary operators of the same cost.
+type opsCost struct {
+	ops  opSet
+	cost int
+}
+
+// binaryOps lists the binary operators in the basis, grouped by cost,
+// in increasing order of cost, and allBinary is the set of them all.
+// They are set by setOps.
+var (
+	binaryOps []opsCost
+	allBinary opSet
+)
+
+// setOps sets binaryOps and allBinary from basis and costs.
+func setOps() {
+	c := costs.Effective()
+	var list []opsCost
+	switch {
+	case basis&checkpoint.Nand != 0:
+		list = append(list, opsCost{opNand, int(c.Nand)})
+	case basis&checkpoint.Nor != 0:
+		list = append(list, opsCost{opNor, int(c.Nor)})
+	default:
+		list = append(list, opsCost{opAnd, int(c.And)}, opsCost{opOr, int(c.Or)})
+		if basis&checkpoint.Xor != 0 {
+			list = append(list, opsCost{opXor, int(c.Xor)})
+		}
+	}
+	binaryOps, allBinary = nil, 0
+	for _, o := range list {
+		allBinary |= o.ops
+		i := sort.Search(len(binaryOps), func(i int) bool { return binaryOps[i].cost >= o.cost })
+		if i < len(binaryOps) && binaryOps[i].cost == o.cost {
+			binaryOps[i].ops |= o.ops
+			continue
+		}
+		binaryOps = append(binaryOps, opsCost{})
+		copy(binaryOps[i+1:], binaryOps[i:])
+		binaryOps[i] = o
+	}
+}
 
-func resetDid() {
-	for i := range did {
-		did[i] = Func(i + 1) // impossible value for slot i
+// appendBinaryTasks appends to tasks the tasks for creating
+// functions of cost targ with the binary operators in basis:
+// for the operators of each cost c, every pair of functions
+// whose costs add up to targ-c.
+func appendBinaryTasks(tasks []exploreTask, bySize [][]Func, targ int) []exploreTask {
+	for _, o := range binaryOps {
+		rest := targ - o.cost
+		if rest < 0 {
+			continue
+		}
+		for i := 0; i+i < rest; i++ {
+			fs := bySize[i]
+			gs := bySize[rest-i]
+			for _, f := range fs {
+				tasks = append(tasks, exploreTask{f: f, gs: gs, ops: o.ops})
+			}
+		}
+		if rest%2 == 0 {
+			gs := bySize[rest/2]
+			for j, f := range gs {
+				tasks = append(tasks, exploreTask{f: f, gs: gs[:j+1], ops: o.ops})
+			}
+		}
 	}
+	return tasks
 }
 
 // explore tries all the inversions and permutations of f
-// and applies them to each of the functions in gs.
-func (q *Queue) explore(f Func, gs []Func, size int) {
+// and applies them to each of the functions in gs,
+// using the operators in ops.
+func (w *worker) explore(f Func, gs []Func, ops opSet) {
+	did := &w.did
+	w.didGen++
+	gen := w.didGen
 	f0 := f
+	top, neg := topBit&31, negFunc
 Gray:
-	for _, i := range grayBit[0:NumInput] {
+	for _, i := range grayBit {
 		m, s := invert[i].mask, invert[i].shift
 		f = (f&m)<<s | (f>>s)&m
-		mask := Func(int32(f<<(32-NumInput))>>31) >> (32 - NumInput)
+		mask := -(f >> top) & neg
 		fc := f ^ mask
-		if did[fc%Func(len(did))] == fc {
+		if d := did[fc%Func(len(did))]; d.f == fc && d.gen == gen {
 			continue Gray
 		}
 
@@ -188,15 +366,15 @@ Gray:
 		for _, j := range permuteBit {
 			k, m, s := swap[j].keep, swap[j].mask, swap[j].shift
 			f = f&k | (f&m)<<s | (f>>s)&m
-			mask := Func(int32(f<<(32-NumInput))>>31) >> (32 - NumInput)
+			mask := -(f >> top) & neg
 			fc := f ^ mask
 			off := fc % Func(len(did))
-			if did[off] == fc {
+			if d := did[off]; d.f == fc && d.gen == gen {
 				continue Perm
 			}
-			did[off] = fc
+			did[off] = didEntry{fc, gen}
 
-			q.explorePair(fc, gs, size)
+			w.explorePair(fc, gs, ops)
 		}
 
 		if f != f1 {
@@ -209,51 +387,255 @@ Gray:
 	}
 }
 
-// explorePair tries the four possible combinations of f and g
-// for each g in gs, calling visit for each new function created.
-func (q *Queue) explorePair(f Func, gs []Func, size int) {
+// An opSet is a set of binary operators.
+type opSet uint8
+
+const (
+	opAnd opSet = 1 << iota
+	opOr
+	opXor
+	opNand
+	opNor
+)
+
+// explorePair tries the possible combinations of f and g
+// using the operators in ops for each g in gs,
+// recording a candidate for each new function created.
+func (w *worker) explorePair(f Func, gs []Func, ops opSet) {
+	visited := visited
+
+	// NAND and NOR have only the one combination.
+	switch ops {
+	case opNand:
+		for _, g := range gs {
+			n := newSize(f, g)
+			if fg := (f & g) ^ allFunc; improves(visited, fg, n) {
+				w.cand = append(w.cand, Record{fg, f, g, 0})
+			}
+		}
+		return
+	case opNor:
+		for _, g := range gs {
+			n := newSize(f, g)
+			if fg := (f | g) ^ allFunc; improves(visited, fg, n) {
+				w.cand = append(w.cand, Record{fg, f, g, 0})
+			}
+		}
+		return
+	}
+
 	// Try combination with all g's.
-	// Can skip half because they're the negations of the
-	// other half.  The ones chosen below are the ones that
-	// preserve top-bit-clear.
+	// With free negation, can skip half because they're the
+	// negations of the other half.  The ones chosen below are
+	// the ones that preserve top-bit-clear.  (AND and OR then
+	// have the same cost, and both are in ops.)  Otherwise,
+	// negating f or g would cost a NOT, tried separately.
+	and, or, xor := ops&opAnd != 0, ops&opOr != 0, ops&opXor != 0
+	free := negFunc != 0
 	for _, g := range gs {
-		fg := f & g
-		if fg != f && fg != g && visited[fg>>6]&(1<<(fg&63)) == 0 {
-			q.visit(fg, f, g, size)
+		n := newSize(f, g)
+		var fg Func
+		if and {
+			fg = f & g
+			if fg != f && fg != g && improves(visited, fg, n) {
+				w.cand = append(w.cand, Record{fg, f, g, 0})
+			}
 		}
 
-		fg = f | g
-		if fg != f && fg != g && visited[fg>>6]&(1<<(fg&63)) == 0 {
-			q.visit(fg, f, g, size)
+		if or {
+			fg = f | g
+			if fg != f && fg != g && improves(visited, fg, n) {
+				w.cand = append(w.cand, Record{fg, f, g, 0})
+			}
 		}
 
-		g1 := g ^ (NumFunc - 1)
-		fg = f & g1
-		if fg != f && visited[fg>>6]&(1<<(fg&63)) == 0 {
-			q.visit(fg, f, g1, size)
-		}
+		if and && free {
+			g1 := g ^ allFunc
+			fg = f & g1
+			if fg != f && improves(visited, fg, n) {
+				w.cand = append(w.cand, Record{fg, f, g1, 0})
+			}
 
-		f1 := f ^ (NumFunc - 1)
-		fg = f1 & g
-		if fg != g && visited[fg>>6]&(1<<(fg&63)) == 0 {
-			q.visit(fg, f1, g, size)
+			f1 := f ^ allFunc
+			fg = f1 & g
+			if fg != g && improves(visited, fg, n) {
+				w.cand = append(w.cand, Record{fg, f1, g, 0})
+			}
 		}
 
-		if *xor {
+		if xor {
 			fg = f ^ g
-			if fg != g && fg != f && visited[fg>>6]&(1<<(fg&63)) == 0 {
-				q.visit(fg, f, g, size)
+			if fg != g && fg != f && improves(visited, fg, n) {
+				w.cand = append(w.cand, Record{fg, f, g, 0})
 			}
 		}
 	}
 }
 
+// A taskOp says which operator an exploreTask tries:
+// the binary operators, using explore, NOT, or a ternary operator.
+// For a ternary operator, it also says in which operand it puts
+// the task's f, which is canonical and has the largest size
+// of the three operands.
+// Every formula with a ternary operator at the top is equivalent
+// to one of these (up to negating and permuting the inputs
+// and negating the output): MUX(s, a, b) is equal to MUX(¬s, b, a),
+// and MAJ is symmetric.
+type taskOp uint8
+
+const (
+	binary   taskOp = iota // call explore
+	negation               // NOT of each function in gs
+	muxIf                  // f ? x : g
+	muxThen                // x ? f : g
+	majority               // MAJ(f, x, g)
+)
+
+// appendTernaryTasks appends to tasks the tasks for creating
+// functions of size targ with the ternary operators in basis.
+func appendTernaryTasks(tasks []exploreTask, bySize [][]Func, targ int) []exploreTask {
+	c := costs.Effective()
+	var ops []taskOp
+	var opCost []int
+	if basis&checkpoint.Mux != 0 {
+		ops = append(ops, muxIf, muxThen)
+		opCost = append(opCost, int(c.Mux), int(c.Mux))
+	}
+	if basis&checkpoint.Maj != 0 {
+		ops = append(ops, majority)
+		opCost = append(opCost, int(c.Maj))
+	}
+	// For each cost, in increasing order,
+	// f has size k, x has size i, g has size j, and i, j <= k.
+	for cost := 1; cost <= checkpoint.MaxCost; cost++ {
+		var opsc []taskOp
+		for n, op := range ops {
+			if opCost[n] == cost {
+				opsc = append(opsc, op)
+			}
+		}
+		if len(opsc) == 0 {
+			continue
+		}
+		for k := 0; k < targ; k++ {
+			for i := 0; i <= k; i++ {
+				j := targ - cost - k - i
+				if j < 0 || j > k {
+					continue
+				}
+				xs, gs := allOfSize(bySize, i), allOfSize(bySize, j)
+				for _, f := range bySize[k] {
+					for _, x := range xs {
+						for _, op := range opsc {
+							tasks = append(tasks, exploreTask{f: f, gs: gs, x: x, op: op})
+						}
+					}
+				}
+			}
+		}
+	}
+	return tasks
+}
+
+// allBySize[i] lists every function of size i, not just the canonical ones.
+// It is filled in as needed by allOfSize.
+var allBySize [][]Func
+
+// allOfSize returns all the functions of size i.
+func allOfSize(bySize [][]Func, i int) []Func {
+	for len(allBySize) <= i {
+		allBySize = append(allBySize, nil)
+	}
+	if allBySize[i] == nil {
+		fs := []Func{}
+		for _, f := range bySize[i] {
+			fs = append(fs, class(f)...)
+		}
+		allBySize[i] = fs
+	}
+	return allBySize[i]
+}
+
+// class returns all the functions equivalent to f.
+func class(f Func) []Func {
+	var fs []Func
+	have := make(map[Func]bool)
+	f0 := f
+	for _, i := range grayBit {
+		m, s := invert[i].mask, invert[i].shift
+		f = (f&m)<<s | (f>>s)&m
+		f1 := f
+		for _, j := range permuteBit {
+			k, m, s := swap[j].keep, swap[j].mask, swap[j].shift
+			f = f&k | (f&m)<<s | (f>>s)&m
+			for _, g := range []Func{f, f ^ negFunc} {
+				if !have[g] {
+					have[g] = true
+					fs = append(fs, g)
+				}
+			}
+		}
+		if f != f1 {
+			panic("class permute did not cycle")
+		}
+	}
+	if f != f0 {
+		panic("class did not cycle")
+	}
+	return fs
+}
+
+// exploreNot tries NOT of each f in fs,
+// recording a candidate for each new function created.
+// It is used only when NOT has a cost, so negation is not free
+// and the functions need no normalizing.
+func (w *worker) exploreNot(fs []Func) {
+	visited := visited
+	for _, f := range fs {
+		if fn := f ^ allFunc; !seen(visited, fn) {
+			w.cand = append(w.cand, Record{fn, f, 0, 0})
+		}
+	}
+}
+
+// exploreTernary tries op with f, x and each g in gs,
+// recording a candidate for each new function created.
+func (w *worker) exploreTernary(op taskOp, f, x Func, gs []Func) {
+	visited, top, all := visited, topBit&31, allFunc
+	for _, g := range gs {
+		var r Record
+		switch op {
+		case muxIf:
+			r = Record{f&x | ^f&g, f, x, g}
+		case muxThen:
+			r = Record{x&f | ^x&g, x, f, g}
+		case majority:
+			r = Record{f&x | f&g | x&g, f, x, g}
+		}
+		// Keep the top bit clear, as visit expects.
+		// Negating the two data inputs of MUX or all the inputs
+		// of MAJ negates the result.
+		if r.F>>top != 0 {
+			r.F ^= all
+			r.Q ^= all
+			r.R ^= all
+			if op == majority {
+				r.P ^= all
+			}
+		}
+		if !seen(visited, r.F) {
+			w.cand = append(w.cand, r)
+		}
+	}
+}
+
 // visit is a no-op if f has already been visited.
 // Otherwise, it computes all the binary functions equivalent
 // to f, marks them all visited (to make future checks easier),
-// and adds f to q.  It also adds p and r as the ``parents'' of f.
-func (q *Queue) visit(f, p, r Func, fsize int) {
-	f0, p0, r0 := f, p, r
+// and adds f to q.  It also adds p, q1 and r as the ``parents'' of f.
+func (q *Queue) visit(f, p, q1, r Func, fsize int) {
+	f0, p0, q0, r0 := f, p, q1, r
+	visited, top, neg := visited, topBit&31, negFunc
 
 	// Have we visited f before?  If so we're done.
 	if visited[f>>6]&(1<<(f&63)) != 0 {
@@ -263,6 +645,7 @@ func (q *Queue) visit(f, p, r Func, fsize int) {
 	// Otherwise set up for minimum.
 	minf := f
 	minp := p
+	minq := q1
 	minr := r
 
 	// Otherwise, try all possible permutations of f's input variables
@@ -271,18 +654,20 @@ func (q *Queue) visit(f, p, r Func, fsize int) {
 
 	// Cycle through inputs, negating according to Gray code
 	// (minimum number of negations).
-	for _, i := range grayBit[0:NumInput] {
+	for _, i := range grayBit {
 		m, s := invert[i].mask, invert[i].shift
 		f = (f&m)<<s | (f>>s)&m
 		p = (p&m)<<s | (p>>s)&m
+		q1 = (q1&m)<<s | (q1>>s)&m
 		r = (r&m)<<s | (r>>s)&m
 
-		mask := Func(int32(f<<(32-NumInput))>>31) >> (32 - NumInput)
+		mask := -(f >> top) & neg
 		fc := f ^ mask
 
 		if fc < minf {
 			minf = fc
 			minp = p ^ mask
+			minq = q1 ^ mask
 			minr = r ^ mask
 		}
 
@@ -291,7 +676,7 @@ func (q *Queue) visit(f, p, r Func, fsize int) {
 			continue
 		}
 
-		f1, p1, r1 := f, p, r
+		f1, p1, q2, r1 := f, p, q1, r
 
 		// Try all possible permutations, swapping according to
 		// permutation sequence.
@@ -299,13 +684,15 @@ func (q *Queue) visit(f, p, r Func, fsize int) {
 			k, m, s := swap[j].keep, swap[j].mask, swap[j].shift
 			f = f&k | (f&m)<<s | (f>>s)&m
 			p = p&k | (p&m)<<s | (p>>s)&m
+			q1 = q1&k | (q1&m)<<s | (q1>>s)&m
 			r = r&k | (r&m)<<s | (r>>s)&m
-			mask := Func(int32(f<<(32-NumInput))>>31) >> (32 - NumInput)
+			mask := -(f >> top) & neg
 			fc := f ^ mask
 
 			if fc < minf {
 				minf = fc
 				minp = p ^ mask
+				minq = q1 ^ mask
 				minr = r ^ mask
 			}
 
@@ -315,23 +702,27 @@ func (q *Queue) visit(f, p, r Func, fsize int) {
 				continue
 			}
 
-			visited[index] |= bit
-			nvisited += 2
-			shift := 5 * (fc % 12)
-			size[fc/12] &= uint64(fsize)<<shift | ^(0x1F << shift)
+			// Workers read visited and size concurrently; see runChunks.
+			atomic.StoreUint64(&visited[index], visited[index]|bit)
+			nvisited += visitCount
+			setSize(fc, fsize)
 		}
 
-		if f != f1 || p != p1 || r != r1 {
+		if f != f1 || p != p1 || q1 != q2 || r != r1 {
 			panic("visit permute did not cycle")
 		}
 	}
 
-	if f != f0 || p != p0 || r != r0 {
+	if f != f0 || p != p0 || q1 != q0 || r != r0 {
 		panic("visit did not cycle")
 	}
+	if r0 == 0 {
+		// There is no third operand to negate.
+		minr = 0
+	}
 
 	*q = append(*q, minf)
-	howto = append(howto, Record{minf, minp, minr})
+	howto = append(howto, Record{minf, minp, minq, minr})
 }
 
 // searchRange scans the visited bitmap for functions fg such that lo <= fg < hi
@@ -342,9 +733,9 @@ func (q *Queue) visit(f, p, r Func, fsize int) {
 // missing function.  However, we run the search by considering only
 // the g that can possibly lead to fg and then deriving all the possible f.
 // This cuts the search pairs quite a bit.
-func (q *Queue) searchRange(lo, hi Func, bySize [][]Func, targ int) (n0, n1, n2 int64) {
+func (w *worker) searchRange(lo, hi Func, bySize [][]Func, targ int) (n0, n1, n2 int64) {
 	for fg := lo &^ 63; fg < hi; {
-		m := visited[fg>>6]
+		m := atomic.LoadUint64(&visited[fg>>6])
 		if ^m == 0 { // all visited
 			fg += 64
 			continue
@@ -352,7 +743,7 @@ func (q *Queue) searchRange(lo, hi Func, bySize [][]Func, targ int) (n0, n1, n2
 		for i := Func(0); i < 64; i++ {
 			if m&(1<<i) == 0 && lo <= fg && fg < hi {
 				n0++
-				nn1, nn2 := q.search(fg, bySize, targ)
+				nn1, nn2 := w.search(fg, bySize, targ)
 				n1 += nn1
 				n2 += nn2
 			}
@@ -362,7 +753,8 @@ func (q *Queue) searchRange(lo, hi Func, bySize [][]Func, targ int) (n0, n1, n2
 	return
 }
 
-func (q *Queue) search(fg Func, bySize [][]Func, targ int) (n1, n2 int64) {
+// search records a candidate for fg if it finds one in the search described above.
+func (w *worker) search(fg Func, bySize [][]Func, targ int) (n1, n2 int64) {
 	for size, bs := range bySize[:targ] {
 		for _, g := range bs {
 			// Which f would give us fg in the search loop?
@@ -371,8 +763,8 @@ func (q *Queue) search(fg Func, bySize [][]Func, targ int) (n1, n2 int64) {
 			// find looks for any possible f (not just canonical).
 			// Need to pick the forms we'll look for.
 
-			g1 := g ^ (NumFunc - 1)
-			fg1 := fg ^ (NumFunc - 1)
+			g1 := g ^ allFunc
+			fg1 := fg ^ allFunc
 
 			// Find f such that fg = f | g.
 			// Only possible if fg | g == fg.
@@ -383,7 +775,7 @@ func (q *Queue) search(fg Func, bySize [][]Func, targ int) (n1, n2 int64) {
 				f, ok, nn := find(fg&^g, g, targ-size-1)
 				n2 += nn
 				if ok {
-					q.visit(fg, f, g, targ)
+					w.cand = append(w.cand, Record{fg, f, g, 0})
 					return
 				}
 			}
@@ -394,7 +786,7 @@ func (q *Queue) search(fg Func, bySize [][]Func, targ int) (n1, n2 int64) {
 				f, ok, nn := find(fg&g, g1, targ-size-1)
 				n2 += nn
 				if ok {
-					q.visit(fg, f, g1, targ)
+					w.cand = append(w.cand, Record{fg, f, g1, 0})
 					return
 				}
 			}
@@ -405,7 +797,7 @@ func (q *Queue) search(fg Func, bySize [][]Func, targ int) (n1, n2 int64) {
 				f, ok, nn := find(fg1&^g, g, targ-size-1)
 				n2 += nn
 				if ok {
-					q.visit(fg, f^(NumFunc-1), g1, targ)
+					w.cand = append(w.cand, Record{fg, f ^ allFunc, g1, 0})
 					return
 				}
 			}
@@ -416,7 +808,7 @@ func (q *Queue) search(fg Func, bySize [][]Func, targ int) (n1, n2 int64) {
 				f, ok, nn := find(fg1&g, g1, targ-size-1)
 				n2 += nn
 				if ok {
-					q.visit(fg, f^(NumFunc-1), g, targ)
+					w.cand = append(w.cand, Record{fg, f ^ allFunc, g, 0})
 					return
 				}
 			}
@@ -429,11 +821,11 @@ func (q *Queue) search(fg Func, bySize [][]Func, targ int) (n1, n2 int64) {
 // (for an arbitrary mask) with the given target size.
 func find(x, canSet Func, targetSize int) (Func, bool, int64) {
 	// Try x.  (Canonicalize to x1.)
-	x1 := x ^ Func(int32(x<<(32-NumInput))>>31)>>(32-NumInput)
+	top, neg := topBit&31, negFunc
+	x1 := x ^ -(x>>top)&neg
 	n := int64(1)
-	if visited[x1>>6]&(1<<(x1&63)) != 0 {
-		xsize := int(size[x1/12]>>(5*(x1%12))) & 0x1F
-		if xsize == targetSize {
+	if seen(visited, x1) {
+		if getSize(x1) == targetSize {
 			return x, true, n
 		}
 	}
@@ -0,0 +1,215 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// See comment at top of compute.go for information about how to run.
+
+package main
+
+import (
+	"log"
+	"runtime"
+	"sort"
+	"time"
+
+	"rsc.io/swtch/boolean-oracle/app/checkpoint"
+)
+
+// With -depth, compute builds a depth table (see checkpoint.Table),
+// in which level d lists the classes whose smallest formulas of
+// depth at most d are smaller than those of depth at most d-1.
+//
+// The smallest formula of depth at most d for a function combines
+// the smallest formulas of depth at most d-1 for its operands,
+// so the sizes at level d can be computed from those at level d-1
+// by trying every pair of classes, except that a pair of classes
+// that did not change at level d-1 gives nothing new.
+// The size table holds the size at the latest level for every
+// function visited, and it does not change while a level is explored:
+// improve collects the best candidate for each class, and commit
+// applies them all once the level is done. Because the workers read
+// only the unchanging tables, the candidates they record, and so
+// the tables written, do not depend on the number of workers.
+// The computation ends at the first level with no changes.
+//
+// Unlike in a size table, the sizes are not bounded by the level,
+// but they must still fit in the size table (see sizeMax).
+
+// pending holds the improvements found at the current level,
+// in the order found, and pendingIndex maps each one's
+// canonical function to its index in pending.
+var (
+	pending      []pendingRecord
+	pendingIndex = make(map[Func]int)
+)
+
+type pendingRecord struct {
+	Record
+	size int
+}
+
+// sizeOf returns the size recorded for f, which must have been visited.
+func sizeOf(f Func) int {
+	return getSize(f ^ -(f>>(topBit&31))&negFunc)
+}
+
+// newSize returns the size of a formula combining f and g
+// with a binary operator when computing a depth table, or else 0.
+func newSize(f, g Func) int {
+	if metric != checkpoint.Depth {
+		return 0
+	}
+	return sizeOf(f) + sizeOf(g) + 1
+}
+
+// improves reports whether a formula of size n for f, which must
+// have its top bit clear if negation is free, would be an improvement
+// over the formula already found, if any. In a size table,
+// the first formula found is the best, so only unvisited functions
+// can be improved; the size n is used only for a depth table.
+func improves(visited []uint64, f Func, n int) bool {
+	return !seen(visited, f) || metric == checkpoint.Depth && sizeOf(f) > n
+}
+
+// improve considers r as a new formula for r.F in a depth table,
+// recording it as pending if it is smaller than the formulas
+// already found or pending.
+func (q *Queue) improve(r Record) {
+	n := sizeOf(r.P) + sizeOf(r.Q) + 1
+	r = canonRecord(r)
+	if seen(visited, r.F) && sizeOf(r.F) <= n {
+		return
+	}
+	if i, ok := pendingIndex[r.F]; ok {
+		if n < pending[i].size {
+			pending[i] = pendingRecord{r, n}
+		}
+		return
+	}
+	pendingIndex[r.F] = len(pending)
+	pending = append(pending, pendingRecord{r, n})
+}
+
+// commit adds the pending improvements to q and howto,
+// updating the visited and size tables.
+func (q *Queue) commit() {
+	for _, p := range pending {
+		if p.size > sizeMax {
+			log.Fatalf("formula for %v has size %d, too large for the size table", p.F, p.size)
+		}
+		mark(p.F, p.size)
+		*q = append(*q, p.F)
+		howto = append(howto, p.Record)
+	}
+	pending = pending[:0]
+	for f := range pendingIndex {
+		delete(pendingIndex, f)
+	}
+}
+
+// canonRecord returns r with r.F replaced by its canonical form
+// and r.P and r.Q transformed to match.
+func canonRecord(r Record) Record {
+	f, p, q1 := r.F, r.P, r.Q
+	best := r
+	top, neg := topBit&31, negFunc
+	for _, i := range grayBit {
+		m, s := invert[i].mask, invert[i].shift
+		f = (f&m)<<s | (f>>s)&m
+		p = (p&m)<<s | (p>>s)&m
+		q1 = (q1&m)<<s | (q1>>s)&m
+		for _, j := range permuteBit {
+			k, m, s := swap[j].keep, swap[j].mask, swap[j].shift
+			f = f&k | (f&m)<<s | (f>>s)&m
+			p = p&k | (p&m)<<s | (p>>s)&m
+			q1 = q1&k | (q1&m)<<s | (q1>>s)&m
+			mask := -(f >> top) & neg
+			if fc := f ^ mask; fc < best.F {
+				best = Record{fc, p ^ mask, q1 ^ mask, 0}
+			}
+		}
+	}
+	if f != r.F || p != r.P || q1 != r.Q {
+		panic("canonRecord did not cycle")
+	}
+	return best
+}
+
+// mark marks all the functions in the class of f visited,
+// with size fsize.
+func mark(f Func, fsize int) {
+	f0 := f
+	top, neg := topBit&31, negFunc
+	for _, i := range grayBit {
+		m, s := invert[i].mask, invert[i].shift
+		f = (f&m)<<s | (f>>s)&m
+		for _, j := range permuteBit {
+			k, m, s := swap[j].keep, swap[j].mask, swap[j].shift
+			f = f&k | (f&m)<<s | (f>>s)&m
+			fc := f ^ -(f>>top)&neg
+			index := fc >> 6
+			bit := uint64(1) << (fc & 63)
+			if visited[index]&bit == 0 {
+				visited[index] |= bit
+				nvisited += visitCount
+			}
+			setSize(fc, fsize)
+		}
+	}
+	if f != f0 {
+		panic("mark did not cycle")
+	}
+}
+
+// exploreDepth computes the levels of the depth table after targ,
+// writing a checkpoint after each one, until a level has no changes.
+func (q *Queue) exploreDepth(bySize [][]Func, targ int) {
+	for targ++; ; targ++ {
+		runtime.GC()
+
+		// All the classes found, in the order found,
+		// and those that changed at the last level,
+		// in the same order.
+		var all []Func
+		pos := make(map[Func]int)
+		for _, h := range howto {
+			if _, ok := pos[h.F]; !ok {
+				pos[h.F] = len(all)
+				all = append(all, h.F)
+			}
+		}
+		changed := append([]Func(nil), bySize[targ-1]...)
+		sort.Slice(changed, func(i, j int) bool { return pos[changed[i]] < pos[changed[j]] })
+
+		// Try each pair of classes, at least one of which changed,
+		// taking the later one as f, to be transformed.
+		t0 := time.Now()
+		var tasks []exploreTask
+		nc := 0
+		for i, f := range all {
+			for nc < len(changed) && pos[changed[nc]] <= i {
+				nc++
+			}
+			if nc > 0 && changed[nc-1] == f {
+				tasks = append(tasks, exploreTask{f: f, gs: all[:i+1], ops: allBinary})
+			} else if nc > 0 {
+				tasks = append(tasks, exploreTask{f: f, gs: changed[:nc], ops: allBinary})
+			}
+		}
+		q.exploreAll(tasks, targ)
+		q.commit()
+		t1 := time.Now()
+
+		bySize[targ] = q.take()
+		log.Println(targ, len(bySize[targ]), nvisited, len(howto), t1.Sub(t0).Seconds())
+		if len(bySize[targ]) == 0 {
+			if nvisited != NumFunc {
+				log.Fatalf("depth %d: no changes, but only %d of %d functions found", targ, nvisited, NumFunc)
+			}
+			break
+		}
+
+		if err := checkpoint.Write(*dir, savepoint(bySize[0:targ+1])); err != nil {
+			log.Printf("writing checkpoint: %v", err)
+		}
+	}
+}
@@ -6,19 +6,44 @@
 package main
 
 import (
-	"encoding/gob"
 	"fmt"
-	"os"
+	"sync/atomic"
+
+	"rsc.io/swtch/boolean-oracle/app/checkpoint"
 )
 
-// Change to compute different answer.
-// Warning: if you set this to 5 the binary needs over 2G of memory to run.
-const NumVar = 4
+// MaxVar is the largest number of variables a Func can represent.
+const MaxVar = 5
+
+// NumVar is the number of input variables being computed,
+// set by setNumVar before the computation begins.
+// Warning: with 5 variables the binary needs over 2G of memory to run,
+// or twice that for the NAND and NOR bases, and more with -costs.
+var NumVar int
+
+// Derived values, also set by setNumVar.
+var (
+	NumInput int    // number of rows in the truth table, 1<<NumVar
+	NumFunc  uint64 // number of functions, 1<<NumInput
+	allFunc  Func   // function with every truth table bit set, NumFunc-1
+	topBit   uint   // index of the top truth table bit, NumInput-1
 
-// Derived constants.
-const (
-	NumInput = 1 << NumVar
-	NumFunc  = 1 << NumInput
+	// When negating a function is free (see checkpoint.Basis.NPN),
+	// only the functions with the top bit clear are tracked:
+	// f is replaced by f ^ -(f>>topBit)&negFunc,
+	// and each one visited stands for two.
+	// Otherwise negFunc is 0 and visitCount is 1.
+	negFunc    Func
+	visitCount uint64
+
+	// The size table packs sizeBits bits for each function,
+	// sizesPerWord functions to a uint64, with all ones (sizeMask)
+	// meaning unknown, so sizeMax is the largest size it can hold.
+	// It uses 5 bits, or 8 with -costs, for which costs can be larger.
+	sizeBits     uint
+	sizesPerWord Func
+	sizeMask     uint64
+	sizeMax      int
 )
 
 // A Func represents a single boolean function.
@@ -27,7 +52,7 @@ const (
 type Func uint32
 
 func (f Func) String() string {
-	return fmt.Sprintf("%#0*x", NumInput/4, uint32(f))
+	return fmt.Sprintf("%#0*x", (NumInput+3)/4, uint32(f))
 }
 
 // literal returns the function whose value is always
@@ -47,44 +72,49 @@ func literal(i int) Func {
 }
 
 // A Record records the Boolean function and its parents
-// for computing it.
+// for computing it. R is used only by ternary operators.
 type Record struct {
-	F, P, Q Func
+	F, P, Q, R Func
 }
 
 // maxFunc[n] is the number of NPN-equivalence classes of
 // Boolean functions of n or fewer variables.
 // It gives the maximum size of the various queues.
+// (There are at most twice as many NP classes.)
 // http://oeis.org/A000370
 var maxFunc = []int{1, 2, 4, 14, 222, 616126}
 
-// grayBit[i] is the bit to flip to turn the i'th gray code into
+// grayBits[i] is the bit to flip to turn the i'th gray code into
 // the i+1'th gray code.
 // http://oeis.org/A007814
 // See also Knuth 7.2.1.1.
-var grayBit = [32]uint8{
+var grayBits = [32]uint8{
 	0, 1, 0, 2, 0, 1, 0, 3,
 	0, 1, 0, 2, 0, 1, 0, 4,
 	0, 1, 0, 2, 0, 1, 0, 3,
 	0, 1, 0, 2, 0, 1, 0, 5,
 }
 
-// Tweak the final entry in the gray bit list
-// to cause a cycle, as a sanity check for our
-// conversions.
-func init() {
-	grayBit[NumInput-1]--
+// grayBit is the first NumInput entries of grayBits,
+// with the final entry tweaked to cause a cycle,
+// as a sanity check for our conversions.
+var grayBit []uint8
+
+// An invertOp describes inverting one input bit of a Func.
+type invertOp struct {
+	mask  Func
+	shift uint
 }
 
+// invert holds the first NumVar entries of invertBits, masked to NumInput bits.
 // To calculate the Func obtained by inverting the i'th input bit of a Func f,
-// swap the bits selected by invert[mask with the bits selected by the shifted mask.
+// swap the bits selected by invert[i].mask with the bits selected by the shifted mask.
 // That is, use
 //	m, s := invert[i].mask, invert[i].shift
 //	f1 := (f&m)<<s | (f>>s)&m
-var invert = []struct {
-	mask  Func
-	shift uint
-}{
+var invert []invertOp
+
+var invertBits = [MaxVar]invertOp{
 	{0x55555555, 1},
 	{0x33333333, 2},
 	{0x0f0f0f0f, 4},
@@ -93,27 +123,101 @@ var invert = []struct {
 }
 
 // permuteBit gives a sequence of adjacent swaps (swap x with x+1)
-// that will cycle through all permutations of a given set.
+// that will cycle through all permutations of the NumVar inputs.
 // See also Knuth 7.2.1.2.
-var permuteBit = computePermuteBit(NumVar)
+var permuteBit []int
 
+// A swapOp describes swapping two adjacent input bits of a Func.
+type swapOp struct {
+	keep  Func
+	mask  Func
+	shift uint
+}
+
+// swap holds the first NumVar-1 entries of swapBits, masked to NumInput bits.
 // To calculate the Func obtained by swapping i'th and i+1'th input bits of a Func f,
 // keep the bits selected by mask keep and then swap the bits selected by mask
 // with the bits selected by the shifted mask.
 // That is, use:
 //	k, m, s := swap[i].keep, swap[i].mask, swap[i].shift
 //	f1 := f&k | (f&m)<<s | (f>>s)&m
-var swap = []struct {
-	keep  Func
-	mask  Func
-	shift uint
-}{
+var swap []swapOp
+
+var swapBits = [MaxVar - 1]swapOp{
 	{0x99999999, 0x22222222, 1},
 	{0xc3c3c3c3, 0x0c0c0c0c, 2},
 	{0xf00ff00f, 0x00f000f0, 4},
 	{0xff0000ff, 0x0000ff00, 8},
 }
 
+// setNumVar sets NumVar to n and derives the values and tables
+// that depend on it and on basis and costs, which must already be set,
+// including the visited and size tables.
+func setNumVar(n int) {
+	if n < 1 || n > MaxVar {
+		panic("setNumVar: invalid number of variables")
+	}
+	NumVar = n
+	NumInput = 1 << n
+	NumFunc = 1 << NumInput
+	allFunc = Func(NumFunc - 1)
+	topBit = uint(NumInput - 1)
+
+	grayBit = append([]uint8(nil), grayBits[:NumInput]...)
+	grayBit[NumInput-1]--
+
+	invert = make([]invertOp, n)
+	for i := range invert {
+		invert[i] = invertOp{invertBits[i].mask & allFunc, invertBits[i].shift}
+	}
+	swap = make([]swapOp, n-1)
+	for i := range swap {
+		swap[i] = swapOp{swapBits[i].keep & allFunc, swapBits[i].mask & allFunc, swapBits[i].shift}
+	}
+	if n == 1 {
+		// There is nothing to permute, but the permutation loops
+		// must still consider the unpermuted function once.
+		// Use a single swap that leaves every bit in place.
+		swap = []swapOp{{allFunc, 0, 0}}
+		permuteBit = []int{0}
+	} else {
+		permuteBit = computePermuteBit(n)
+	}
+
+	nfunc := NumFunc
+	if costs.NPN(basis) {
+		negFunc = allFunc
+		visitCount = 2
+		nfunc /= 2
+	} else {
+		negFunc = 0
+		visitCount = 1
+	}
+	sizeBits = 5
+	if costs != (checkpoint.Costs{}) {
+		sizeBits = 8
+	}
+	sizesPerWord = Func(64 / sizeBits)
+	sizeMask = 1<<sizeBits - 1
+	sizeMax = int(sizeMask) - 1
+	visited = make([]uint64, (nfunc+64-1)/64)
+	size = make([]uint64, (nfunc+uint64(sizesPerWord)-1)/uint64(sizesPerWord))
+}
+
+// getSize returns the size recorded for f in the size table,
+// or sizeMask if there is none.
+// Workers read size while visit may be updating it.
+func getSize(f Func) int {
+	shift := sizeBits * uint(f%sizesPerWord)
+	return int(atomic.LoadUint64(&size[f/sizesPerWord]) >> shift & sizeMask)
+}
+
+// setSize records n as the size of f in the size table.
+func setSize(f Func, n int) {
+	i, shift := f/sizesPerWord, sizeBits*uint(f%sizesPerWord)
+	atomic.StoreUint64(&size[i], size[i]&^(sizeMask<<shift)|uint64(n)<<shift)
+}
+
 // Generate permuteBit sequence for n.
 // Algorithm is from Knuth 7.2.1.2 Algorithm P (Plain changes).
 // 17th century bell ringing algorithm.
@@ -173,10 +277,11 @@ func fact(i int) int {
 func findMin(f Func) Func {
 	minf := f
 	f0 := f
-	for _, i := range grayBit[0:NumInput] {
+	top, all := topBit&31, negFunc
+	for _, i := range grayBit {
 		m, s := invert[i].mask, invert[i].shift
 		f = (f&m)<<s | (f>>s)&m
-		mask := Func(int32(f<<(32-NumInput))>>31) >> (32 - NumInput)
+		mask := -(f >> top) & all
 		fc := f ^ mask
 
 		if fc < minf {
@@ -187,7 +292,7 @@ func findMin(f Func) Func {
 		for _, j := range permuteBit {
 			k, m, s := swap[j].keep, swap[j].mask, swap[j].shift
 			f = f&k | (f&m)<<s | (f>>s)&m
-			mask := Func(int32(f<<(32-NumInput))>>31) >> (32 - NumInput)
+			mask := -(f >> top) & all
 			fc := f ^ mask
 
 			if fc < minf {
@@ -208,32 +313,19 @@ func findMin(f Func) Func {
 	return minf
 }
 
-// A Savepoint records information for starting again later.
-type Savepoint struct {
-	Howto  []Record
-	BySize [][]Func
-}
-
-func gobMarshal(name string, data interface{}) error {
-	f, err := os.Create(name)
-	if err != nil {
-		panic(err)
-	}
-	err = gob.NewEncoder(f).Encode(data)
-	if err != nil {
-		panic(err)
+// savepoint returns the checkpoint table for the functions found so far,
+// listed by size in bySize.
+func savepoint(bySize [][]Func) *checkpoint.Table {
+	t := &checkpoint.Table{
+		Kind:   kind(),
+		Howto:  make([]checkpoint.Record, len(howto)),
+		Counts: make([]int, len(bySize)),
 	}
-	return nil
-}
-
-func gobUnmarshal(name string, data interface{}) error {
-	f, err := os.Open(name)
-	if err != nil {
-		return err
+	for i, h := range howto {
+		t.Howto[i] = checkpoint.Record{F: uint32(h.F), P: uint32(h.P), Q: uint32(h.Q), R: uint32(h.R)}
 	}
-	err = gob.NewDecoder(f).Decode(data)
-	if err != nil {
-		panic(err)
+	for i, fs := range bySize {
+		t.Counts[i] = len(fs)
 	}
-	return nil
+	return t
 }
@@ -0,0 +1,186 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"runtime"
+	"sync/atomic"
+
+	"rsc.io/swtch/boolean-oracle/app/checkpoint"
+)
+
+var procs = flag.Int("procs", runtime.NumCPU(), "number of goroutines to use for each level")
+
+// The work for each level is split into chunks, each handled by a worker.
+// A worker does not change the shared state (visited, size, howto and
+// the queue). Instead, it records the candidates it finds, the functions
+// it would have passed to visit, and runChunks calls visit for them
+// one chunk at a time, in chunk order.
+//
+// Workers skip functions already marked in visited, which they read
+// while runChunks is updating it. While a worker runs, visited holds
+// only marks made for earlier chunks, a subset of those the serial
+// computation would have made by the same point, so the worker never skips
+// a function that the serial computation would have passed to visit.
+// It may record extra candidates, but by the time runChunks gets to them,
+// visit finds them already visited and ignores them. The calls that do anything
+// are therefore exactly those of the serial computation, in the same order,
+// and the tables are the same no matter how many workers run.
+
+// A worker explores chunks of a level.
+type worker struct {
+	cand       []Record // candidates for visit, in order
+	n0, n1, n2 int64    // search statistics
+
+	// did is a probabilistic data structure for tracking which
+	// functions f have already been explored by the current call
+	// to explore, which is numbered didGen.  The exploration
+	// of f begins by setting did[f%len(f)] = {f, didGen}, so if
+	// did[f%len(f)] is {f, didGen}, then f has been explored.
+	// Because multiple f hash to the same array index, the opposite
+	// does not guarantee that f is unexplored, but the possibility
+	// is rare and the duplicated effort harmless if inefficient.
+	//
+	// The probabilistic check gives O(1) lookup and O(1) insertion times,
+	// in contrast to the larger times for a precise sorted or unsorted list,
+	// and it is utterly trivial to implement.
+	//
+	// The entries expire at the end of each call, because with -costs,
+	// the same f is explored once for each cost of operator, with different
+	// functions g.  Each worker has its own, so even within a call,
+	// a function may be explored once per worker, again harmlessly.
+	did    [100003]didEntry
+	didGen uint32
+}
+
+type didEntry struct {
+	f   Func
+	gen uint32 // never 0, so the zero didEntry is empty
+}
+
+func newWorker() *worker {
+	return new(worker)
+}
+
+// seen reports whether f is marked in the visited bitmap.
+// Workers use it to read visited while visit may be updating it.
+func seen(visited []uint64, f Func) bool {
+	return atomic.LoadUint64(&visited[f>>6])&(1<<(f&63)) != 0
+}
+
+// runChunks calls work(w, k) for each chunk k from 0 to n-1,
+// using up to *procs goroutines at a time, and passes the
+// candidates each records to visit, in chunk order, with size fsize,
+// or, when computing a depth table, to improve.
+// It returns the sums of the workers' search statistics.
+func (q *Queue) runChunks(n, fsize int, work func(w *worker, k int)) (n0, n1, n2 int64) {
+	p := *procs
+	if p < 1 {
+		p = 1
+	}
+
+	// Keep at most 2*p chunks in flight, so that workers
+	// do not run far ahead of the merge, and reuse their did tables.
+	free := make(chan *worker, 2*p)
+	for i := 0; i < cap(free); i++ {
+		free <- newWorker()
+	}
+	done := make([]chan *worker, n)
+	for k := range done {
+		done[k] = make(chan *worker, 1)
+	}
+	go func() {
+		sema := make(chan bool, p)
+		for k := 0; k < n; k++ {
+			w := <-free
+			sema <- true
+			go func(k int, w *worker) {
+				work(w, k)
+				<-sema
+				done[k] <- w
+			}(k, w)
+		}
+	}()
+
+	for k := 0; k < n; k++ {
+		w := <-done[k]
+		for _, c := range w.cand {
+			if metric == checkpoint.Depth {
+				q.improve(c)
+			} else {
+				q.visit(c.F, c.P, c.Q, c.R, fsize)
+			}
+		}
+		n0 += w.n0
+		n1 += w.n1
+		n2 += w.n2
+		w.cand = w.cand[:0]
+		w.n0, w.n1, w.n2 = 0, 0, 0
+		free <- w
+	}
+	return
+}
+
+// An exploreTask is a call to explore, with the operators in ops,
+// or, if op is not binary, to exploreNot or exploreTernary.
+type exploreTask struct {
+	f   Func
+	gs  []Func
+	x   Func
+	op  taskOp
+	ops opSet
+}
+
+// minChunkPairs is the minimum number of functions g
+// in each chunk of exploreAll's work.
+const minChunkPairs = 1024
+
+// exploreAll runs explore for each of tasks, in parallel,
+// visiting the functions created, which have size fsize.
+func (q *Queue) exploreAll(tasks []exploreTask, fsize int) {
+	// Group small tasks into chunks to amortize the overhead.
+	var chunks [][]exploreTask
+	start, pairs := 0, 0
+	for i, t := range tasks {
+		pairs += len(t.gs)
+		if pairs >= minChunkPairs || i == len(tasks)-1 {
+			chunks = append(chunks, tasks[start:i+1])
+			start, pairs = i+1, 0
+		}
+	}
+	q.runChunks(len(chunks), fsize, func(w *worker, k int) {
+		for _, t := range chunks[k] {
+			switch t.op {
+			case binary:
+				w.explore(t.f, t.gs, t.ops)
+			case negation:
+				w.exploreNot(t.gs)
+			default:
+				w.exploreTernary(t.op, t.f, t.x, t.gs)
+			}
+		}
+	})
+}
+
+// searchChunk is the number of functions in each chunk of searchAll's work.
+const searchChunk = 1 << 16
+
+// searchAll runs searchRange over all functions with the top bit clear, in parallel,
+// visiting the functions found, which have size targ.
+func (q *Queue) searchAll(bySize [][]Func, targ int) (n0, n1, n2 int64) {
+	end := NumFunc / 2
+	n := int((end + searchChunk - 1) / searchChunk)
+	return q.runChunks(n, targ, func(w *worker, k int) {
+		lo := uint64(k) * searchChunk
+		hi := lo + searchChunk
+		if hi > end {
+			hi = end
+		}
+		n0, n1, n2 := w.searchRange(Func(lo), Func(hi), bySize, targ)
+		w.n0 += n0
+		w.n1 += n1
+		w.n2 += n2
+	})
+}
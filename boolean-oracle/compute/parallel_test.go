@@ -0,0 +1,91 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMain runs the test binary as compute itself when
+// $COMPUTE_TEST_MAIN is set, so that each computation in the tests
+// runs in a fresh process: compute keeps its state in global variables.
+func TestMain(m *testing.M) {
+	if os.Getenv("COMPUTE_TEST_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runCompute runs compute with the given arguments, writing the
+// checkpoint files to a new directory, which it returns.
+func runCompute(t *testing.T, args ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	cmd := exec.Command(os.Args[0], append(args, "-dir", dir)...)
+	cmd.Env = append(os.Environ(), "COMPUTE_TEST_MAIN=1")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("compute %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return dir
+}
+
+// TestParallel checks that the checkpoint files written by compute
+// are the same whatever the number of goroutines it uses.
+func TestParallel(t *testing.T) {
+	for _, args := range []string{
+		"-n 3",
+		"-n 3 -xor",
+		"-n 3 -depth",
+		"-n 4",
+		"-n 4 -xor",
+		"-n 4 -depth",
+	} {
+		args := args
+		t.Run(args, func(t *testing.T) {
+			if testing.Short() && strings.Contains(args, "-n 4") {
+				t.Skip("skipping 4 variables in short mode")
+			}
+			serial := runCompute(t, append(strings.Fields(args), "-procs=1")...)
+			parallel := runCompute(t, append(strings.Fields(args), "-procs=8")...)
+			compareCheckpoints(t, serial, parallel)
+		})
+	}
+}
+
+// compareCheckpoints checks that dirs want and got hold
+// the same checkpoint files, with the same contents.
+func compareCheckpoints(t *testing.T, want, got string) {
+	t.Helper()
+	wantFiles, _ := filepath.Glob(filepath.Join(want, "*.ckpt"))
+	gotFiles, _ := filepath.Glob(filepath.Join(got, "*.ckpt"))
+	if len(wantFiles) == 0 {
+		t.Fatalf("no checkpoint files written")
+	}
+	if len(gotFiles) != len(wantFiles) {
+		t.Fatalf("parallel run wrote %d checkpoint files, serial run %d", len(gotFiles), len(wantFiles))
+	}
+	for i, file := range wantFiles {
+		name := filepath.Base(file)
+		if g := filepath.Base(gotFiles[i]); g != name {
+			t.Fatalf("parallel run wrote %s, serial run %s", g, name)
+		}
+		w, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g, err := os.ReadFile(gotFiles[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(g, w) {
+			t.Errorf("%s differs between serial and parallel runs", name)
+		}
+	}
+}
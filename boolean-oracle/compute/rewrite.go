@@ -3,8 +3,16 @@
 
 //go:build ignore
 
-// This program rewrites "gob" encoded tables into raw binary tables.
-// The latter require less memory to decode on App Engine startup.
+// This program converts tables written by older versions of compute,
+// either "gob" encoded (.gob) or as raw binary tables (.raw),
+// into checkpoint files.
+//
+// Usage:
+//
+//	go run rewrite.go [-dir dir] file...
+//
+// The number of variables, basis and level are taken from
+// each file's name, such as xor.a056287.5.12.raw.
 
 package main
 
@@ -12,13 +20,20 @@ import (
 	"bufio"
 	"encoding/binary"
 	"encoding/gob"
+	"flag"
+	"fmt"
 	"io"
 	"log"
 	"os"
-	"reflect"
-	"runtime"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"rsc.io/swtch/boolean-oracle/app/checkpoint"
 )
 
+var dir = flag.String("dir", ".", "directory for checkpoint files")
+
 type Func uint32
 
 type Record struct {
@@ -45,68 +60,28 @@ func gobUnmarshal(name string, data interface{}) error {
 		}
 		f = io.MultiReader(f1, f2)
 	}
-	println("decode", name)
-	err = gob.NewDecoder(f).Decode(data)
-	println("done", len(data.(*Savepoint).Howto))
-	runtime.GC()
-	if err != nil {
-		panic(err)
-	}
-	return nil
-}
-
-type intwriter struct {
-	*bufio.Writer
-	buf [4]byte
-}
-
-func (w *intwriter) Write(x uint32) {
-	binary.BigEndian.PutUint32(w.buf[:], x)
-	w.Writer.Write(w.buf[:])
+	return gob.NewDecoder(f).Decode(data)
 }
 
 type intreader struct {
 	*bufio.Reader
 	buf [4]byte
+	err error
 }
 
 func (r *intreader) Read() uint32 {
-	n, err := io.ReadFull(r.Reader, r.buf[:])
-	if n != 4 {
-		log.Fatal(err)
+	if _, err := io.ReadFull(r.Reader, r.buf[:]); err != nil && r.err == nil {
+		r.err = err
 	}
 	return binary.BigEndian.Uint32(r.buf[:])
 }
 
-func rawMarshal(name string, sp *Savepoint) {
-	f, err := os.Create(name)
-	if err != nil {
-		log.Fatal(err)
-	}
-	w := &intwriter{Writer: bufio.NewWriter(f)}
-	w.Write(uint32(len(sp.Howto)))
-	for _, r := range sp.Howto {
-		w.Write(uint32(r.F))
-		w.Write(uint32(r.P))
-		w.Write(uint32(r.Q))
-	}
-	w.Write(uint32(len(sp.BySize)))
-	for _, ff := range sp.BySize {
-		w.Write(uint32(len(ff)))
-		for _, f := range ff {
-			w.Write(uint32(f))
-		}
-	}
-	if err := w.Flush(); err != nil {
-		log.Fatal(err)
-	}
-}
-
-func rawUnmarshal(name string, sp *Savepoint) {
+func rawUnmarshal(name string, sp *Savepoint) error {
 	f, err := os.Open(name)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	defer f.Close()
 	r := &intreader{Reader: bufio.NewReader(f)}
 	sp.Howto = make([]Record, r.Read())
 	for i := range sp.Howto {
@@ -124,18 +99,75 @@ func rawUnmarshal(name string, sp *Savepoint) {
 		}
 		sp.BySize[i] = x
 	}
+	return r.err
 }
 
-func main() {
+// parseName parses a table file name like xor.a056287.5.12.raw.
+func parseName(file string) (numVar int, basis checkpoint.Basis, level int, err error) {
+	name := filepath.Base(file)
+	if strings.HasPrefix(name, "xor.") {
+		basis |= checkpoint.Xor
+		name = strings.TrimPrefix(name, "xor.")
+	}
+	f := strings.Split(name, ".")
+	if len(f) != 4 || f[0] != "a056287" {
+		return 0, 0, 0, fmt.Errorf("%s: cannot parse file name", file)
+	}
+	numVar, err1 := strconv.Atoi(f[1])
+	level, err2 := strconv.Atoi(f[2])
+	if err1 != nil || err2 != nil {
+		return 0, 0, 0, fmt.Errorf("%s: cannot parse file name", file)
+	}
+	return numVar, basis, level, nil
+}
+
+func convert(file string) error {
+	numVar, basis, level, err := parseName(file)
+	if err != nil {
+		return err
+	}
 	var sp Savepoint
+	if strings.HasSuffix(file, ".raw") {
+		err = rawUnmarshal(file, &sp)
+	} else {
+		err = gobUnmarshal(file, &sp)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+	if len(sp.BySize) != level+1 {
+		return fmt.Errorf("%s: table has %d levels, want %d", file, len(sp.BySize), level+1)
+	}
 
-	if err := gobUnmarshal(os.Args[1], &sp); err != nil {
-		log.Fatal(err)
+	t := &checkpoint.Table{Kind: checkpoint.Kind{NumVar: numVar, Basis: basis}}
+	for _, r := range sp.Howto {
+		t.Howto = append(t.Howto, checkpoint.Record{F: uint32(r.F), P: uint32(r.P), Q: uint32(r.Q)})
 	}
-	rawMarshal(os.Args[2], &sp)
-	var sp2 Savepoint
-	rawUnmarshal(os.Args[2], &sp2)
-	if !reflect.DeepEqual(&sp, &sp2) {
-		log.Fatal("not equal")
+	n := 0
+	for _, ff := range sp.BySize {
+		for _, f := range ff {
+			if n >= len(sp.Howto) || sp.Howto[n].F != f {
+				return fmt.Errorf("%s: inconsistent state", file)
+			}
+			n++
+		}
+		t.Counts = append(t.Counts, len(ff))
+	}
+	if n != len(sp.Howto) {
+		return fmt.Errorf("%s: inconsistent state", file)
+	}
+	if err := checkpoint.Write(*dir, t); err != nil {
+		return err
+	}
+	log.Printf("%s: wrote %s", file, checkpoint.Name(t.Kind, level))
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	for _, file := range flag.Args() {
+		if err := convert(file); err != nil {
+			log.Fatal(err)
+		}
 	}
 }
@@ -0,0 +1,77 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"rsc.io/swtch/boolean-oracle/app/checkpoint"
+	"rsc.io/swtch/boolean-oracle/app/circuit"
+	"rsc.io/swtch/boolean-oracle/app/table"
+)
+
+// exportCircuits prints the circuit table ct to w
+// in the format given by the -format flag.
+func exportCircuits(w *bufio.Writer, ct *checkpoint.Table) {
+	t, err := circuit.NewTable(ct)
+	if err != nil {
+		log.Fatal(err)
+	}
+	list := append([]*circuit.Circuit(nil), t.Circuits...)
+	sort.Slice(list, func(i, j int) bool { return list[i].F < list[j].F })
+
+	switch *format {
+	default:
+		log.Fatalf("unknown format %q", *format)
+	case "tsv":
+		fmt.Fprintf(w, "F\tgates\tgate\top\tP\tQ\n")
+		for _, c := range list {
+			if c.Size() == 0 {
+				fmt.Fprintf(w, "%s\t0\t%s\tLit\t%s\t%s\n", t.Format(c.F), t.Format(c.F), t.Format(c.F), t.Format(0))
+				continue
+			}
+			for i, r := range circuit.Records(c) {
+				fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\n", t.Format(c.F), c.Size(), t.Format(table.Func(r.F)), c.Gates[i].Op, t.Format(table.Func(r.P)), t.Format(table.Func(r.Q)))
+			}
+		}
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		for _, c := range list {
+			enc.Encode(struct {
+				F     string
+				Gates int
+				Lines []string
+			}{t.Format(c.F), c.Size(), c.Lines()})
+		}
+	case "formula":
+		for _, c := range list {
+			fmt.Fprintf(w, "%s\t%d\t%s\n", t.Format(c.F), c.Size(), strings.Join(c.Lines(), "; "))
+		}
+	case "counts":
+		var classes, funcs []int
+		for _, c := range list {
+			n := c.Size()
+			for len(classes) <= n {
+				classes = append(classes, 0)
+				funcs = append(funcs, 0)
+			}
+			classes[n]++
+			funcs[n] += t.ClassSize(c.F)
+		}
+		fmt.Fprintf(w, "gates\tclasses\tfunctions\n")
+		nc, nf := 0, 0
+		for n := range classes {
+			fmt.Fprintf(w, "%d\t%d\t%d\n", n, classes[n], funcs[n])
+			nc += classes[n]
+			nf += funcs[n]
+		}
+		fmt.Fprintf(w, "total\t%d\t%d\n", nc, nf)
+	}
+}
@@ -0,0 +1,221 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Export prints the table in a checkpoint file written by
+// boolean-oracle/compute as text.
+//
+// Usage:
+//
+//	export [-format f] file.ckpt
+//
+// The -format flag selects the output format:
+//
+//	tsv      one tab-separated line for each class, giving its
+//	         canonical function, size, operator and the two
+//	         (or, for a ternary operator, three) functions
+//	         it is computed from (the default)
+//	json     one JSON object for each class, with the same fields
+//	formula  one tab-separated line for each class, giving its
+//	         canonical function, size and a minimal formula
+//	counts   for each size, the number of classes and the number
+//	         of functions of that size, followed by the totals
+//
+// The classes are NPN classes, or NP classes for the NAND and NOR bases;
+// see rsc.io/swtch/boolean-oracle/app/table.
+//
+// For a depth table, the tsv and json formats print every entry,
+// with its depth before its size, so that a class may have several
+// lines, and the formula and counts formats print only the entry of
+// minimal depth for each class, with the counts given by depth.
+// The formulas for a depth table are fully parenthesized,
+// to show their depth.
+//
+// For a table with costs (see checkpoint.Costs), every format
+// gives the cost before the size, and the counts are given by cost.
+//
+// For a circuit table, each format gives the number of gates instead
+// of the size: the tsv format prints one line for each gate of each
+// class's circuit, with the function it computes, its operator and
+// the two functions it is computed from, the json format gives each
+// circuit's gate list, as in "g1 = v & w", the formula format prints
+// the gate list on one line, separated by semicolons, and the counts
+// are given by number of gates.
+//
+// Functions are printed as hexadecimal truth tables, in which bit k
+// is the value of the function for the input k, whose bit i gives
+// the value of variable i. In formulas, the variables are named
+// v, w, x, y and z. The lines are sorted by canonical function.
+//
+// The counts for the tables of 5 variables can be checked against
+// OEIS A056287 (AND and OR) and A178939 (AND, OR and XOR).
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"rsc.io/swtch/boolean-oracle/app/checkpoint"
+	"rsc.io/swtch/boolean-oracle/app/table"
+)
+
+var format = flag.String("format", "tsv", "output format: tsv, json, formula or counts")
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: export [-format f] file.ckpt\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("export: ")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 1 {
+		usage()
+	}
+	ct, err := checkpoint.Read(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if ct.Metric == checkpoint.Circuit {
+		w := bufio.NewWriter(os.Stdout)
+		exportCircuits(w, ct)
+		if err := w.Flush(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	t, err := table.New(ct)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	depth := t.Metric == checkpoint.Depth
+	withCosts := t.Costs != (checkpoint.Costs{})
+	w := bufio.NewWriter(os.Stdout)
+	switch *format {
+	default:
+		log.Fatalf("unknown format %q", *format)
+	case "tsv":
+		ternary := t.Basis.Ternary()
+		switch {
+		case depth:
+			fmt.Fprintf(w, "F\tdepth\t")
+		case withCosts:
+			fmt.Fprintf(w, "F\tcost\t")
+		default:
+			fmt.Fprintf(w, "F\t")
+		}
+		if ternary {
+			fmt.Fprintf(w, "size\top\tP\tQ\tR\n")
+		} else {
+			fmt.Fprintf(w, "size\top\tP\tQ\n")
+		}
+		for i := range t.Entries {
+			e := &t.Entries[i]
+			fmt.Fprintf(w, "%s\t", t.Format(e.F))
+			switch {
+			case depth:
+				fmt.Fprintf(w, "%d\t", e.Depth)
+			case withCosts:
+				fmt.Fprintf(w, "%d\t", e.Cost)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s", e.Size, t.Op(e), t.Format(e.P), t.Format(e.Q))
+			if ternary {
+				fmt.Fprintf(w, "\t%s", t.Format(e.R))
+			}
+			fmt.Fprintf(w, "\n")
+		}
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		for i := range t.Entries {
+			e := &t.Entries[i]
+			var r string
+			if e.R != 0 {
+				r = t.Format(e.R)
+			}
+			var cost *int
+			if withCosts {
+				cost = &e.Cost
+			}
+			enc.Encode(struct {
+				F     string
+				Depth int  `json:",omitempty"`
+				Cost  *int `json:",omitempty"`
+				Size  int
+				Op    string
+				P, Q  string
+				R     string `json:",omitempty"`
+			}{t.Format(e.F), e.Depth, cost, e.Size, t.Op(e), t.Format(e.P), t.Format(e.Q), r})
+		}
+	case "formula":
+		for _, e := range classEntries(t) {
+			tree, err := t.Tree(e.F)
+			if err != nil {
+				log.Fatal(err)
+			}
+			switch {
+			case depth:
+				fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", t.Format(e.F), e.Depth, e.Size, tree.GroupedString())
+			case withCosts:
+				fmt.Fprintf(w, "%s\t%d\t%d\t%v\n", t.Format(e.F), e.Cost, e.Size, tree)
+			default:
+				fmt.Fprintf(w, "%s\t%d\t%v\n", t.Format(e.F), e.Size, tree)
+			}
+		}
+	case "counts":
+		var classes, funcs []int
+		name := "size"
+		switch {
+		case depth:
+			name = "depth"
+		case withCosts:
+			name = "cost"
+		}
+		for _, e := range classEntries(t) {
+			n := e.Size
+			switch {
+			case depth:
+				n = e.Depth
+			case withCosts:
+				n = e.Cost
+			}
+			for len(classes) <= n {
+				classes = append(classes, 0)
+				funcs = append(funcs, 0)
+			}
+			classes[n]++
+			funcs[n] += t.ClassSize(e.F)
+		}
+		fmt.Fprintf(w, "%s\tclasses\tfunctions\n", name)
+		nc, nf := 0, 0
+		for size := range classes {
+			fmt.Fprintf(w, "%d\t%d\t%d\n", size, classes[size], funcs[size])
+			nc += classes[size]
+			nf += funcs[size]
+		}
+		fmt.Fprintf(w, "total\t%d\t%d\n", nc, nf)
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// classEntries returns the entry of minimal depth for each class in t,
+// which for a size table is every entry.
+func classEntries(t *table.Table) []*table.Entry {
+	var list []*table.Entry
+	for i := range t.Entries {
+		e := &t.Entries[i]
+		if i == 0 || e.F != t.Entries[i-1].F {
+			list = append(list, e)
+		}
+	}
+	return list
+}
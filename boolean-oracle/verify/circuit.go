@@ -0,0 +1,98 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"rsc.io/swtch/boolean-oracle/app/checkpoint"
+	"rsc.io/swtch/boolean-oracle/app/circuit"
+)
+
+// knownCircuitCounts[basis][n][gates] is the number of NPN classes
+// (or NP classes) of functions of n variables whose minimal circuits
+// in the basis have the given number of gates, as computed by earlier
+// runs of compute -circuit. With XOR, for 4 variables, they match
+// the counts in Knuth, TAOCP Volume 4A, Section 7.1.2, except that
+// there the constant functions take no gates.
+var knownCircuitCounts = map[checkpoint.Basis][][]int{
+	0: {
+		1: {1, 1},
+		2: {1, 2, 0, 1},
+		3: {1, 2, 2, 2, 4, 1, 2},
+	},
+	checkpoint.Xor: {
+		1: {1, 1},
+		2: {1, 3},
+		3: {1, 3, 5, 3, 2},
+		4: {1, 3, 5, 20, 34, 75, 72, 12},
+	},
+	checkpoint.Nand: nandCircuitCounts,
+	checkpoint.Nor:  nandCircuitCounts, // NOR circuits are the duals of NAND circuits
+}
+
+// nandCircuitCounts are the counts of NP classes for the NAND basis.
+var nandCircuitCounts = [][]int{
+	1: {1, 1, 1},
+	2: {1, 2, 2, 1},
+	3: {1, 2, 3, 4, 4, 4, 2, 2},
+}
+
+// verifyCircuits checks the circuit table ct, read from file,
+// and reports whether it is valid. Each run of records must be a circuit,
+// with operators in the table's basis, computing the canonical function
+// of its class, and every class must be present, with the known counts.
+// The minimality of the circuits is not checked: that would take
+// as long as computing the table.
+func verifyCircuits(file string, ct *checkpoint.Table) bool {
+	c := &checker{file: file}
+	t, err := circuit.NewTable(ct)
+	if err != nil {
+		log.Printf("%s: %v", file, err)
+		return false
+	}
+
+	counts := make([]int, len(ct.Counts))
+	for _, x := range t.Circuits {
+		if f := t.Canon(x.F); f != x.F {
+			c.errorf("circuit for %s does not compute the canonical function %s", t.Format(x.F), t.Format(f))
+		}
+		counts[x.Size()]++
+	}
+
+	wantClass := numClass[t.NumVar]
+	if !t.Basis.NPN() {
+		wantClass = numNPClass[t.NumVar]
+	}
+	nclass := len(t.Circuits)
+	complete := nclass == wantClass
+	if !complete && !*partial {
+		c.errorf("table is incomplete: %d of %d classes", nclass, wantClass)
+	}
+
+	if known := knownCircuitCounts[t.Basis]; t.NumVar < len(known) && known[t.NumVar] != nil {
+		k := known[t.NumVar]
+		if complete && len(counts) != len(k) {
+			c.errorf("table has circuits of up to %d gates, want %d", len(counts)-1, len(k)-1)
+		}
+		for level, n := range counts {
+			if level < len(k) && n != k[level] {
+				c.errorf("%d classes of %d gates, want %d", n, level, k[level])
+			}
+		}
+	} else {
+		log.Printf("%s: no known counts for %d variables in this basis", file, t.NumVar)
+	}
+
+	if c.n > 0 {
+		return false
+	}
+	status := "complete"
+	if !complete {
+		status = fmt.Sprintf("incomplete, %d of %d classes", nclass, wantClass)
+	}
+	fmt.Printf("%s: ok: %d classes, gates 0-%d (%s)\n", file, nclass, ct.Level(), status)
+	return true
+}
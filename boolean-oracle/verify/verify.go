@@ -0,0 +1,448 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Verify checks the tables in checkpoint files written by
+// boolean-oracle/compute.
+//
+// Usage:
+//
+//	verify [-partial] file.ckpt...
+//
+// For each table, verify checks that:
+//
+//   - each record's function F is the canonical function of its class,
+//     and no class has more than one record;
+//   - each record computes F from P and Q (and, for a ternary operator, R)
+//     using an operator in the table's basis, or records a literal at size 0;
+//   - each record's size is one more than the sizes of the classes
+//     of its operands, or in a table with costs (see checkpoint.Costs),
+//     its cost is the operator's cost plus the costs of the operands;
+//   - the minimal formula for each class, expanded from the records,
+//     evaluates to F and has the recorded size (and cost);
+//   - every NPN class is present, so that the classes cover
+//     all the functions of the table's number of variables;
+//   - the number of classes of each size matches the known counts.
+//
+// In a depth table (see checkpoint.Table), a class may have several
+// records, at increasing depths and with decreasing sizes; the sizes
+// are derived from the operands, and the formula for each record
+// must have the record's depth. The known counts are those of classes
+// by minimal depth.
+//
+// In a table with costs, the levels are costs, and the sizes are derived
+// from the operands, as in a depth table. There are no known counts
+// for tables with costs.
+//
+// In a circuit table, each run of records must describe a circuit
+// computing the canonical function of its class, every class must
+// be present, and the number of classes with each number of gates
+// must match the known counts; see circuit.go.
+//
+// The -partial flag allows tables that stop before the final level,
+// like the intermediate checkpoints written during a computation.
+// For those, the counts are checked only for the sizes in the table.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"sync"
+
+	"rsc.io/swtch/boolean-oracle/app/checkpoint"
+	"rsc.io/swtch/boolean-oracle/app/table"
+)
+
+var partial = flag.Bool("partial", false, "allow incomplete tables")
+
+// maxErrors is the number of errors reported for each table
+// before giving up on it.
+const maxErrors = 20
+
+// numClass[n] is the number of NPN-equivalence classes of
+// Boolean functions of n or fewer variables.
+// http://oeis.org/A000370
+var numClass = []int{1, 2, 4, 14, 222, 616126}
+
+// numNPClass[n] is the number of NP-equivalence classes,
+// used in the NAND and NOR bases.
+// http://oeis.org/A000231
+var numNPClass = []int{1, 3, 6, 22, 402, 1228158}
+
+// knownCounts[basis][n][size] is the number of NPN classes of functions
+// of n variables whose minimal formulas in the basis have the given size,
+// as computed by earlier runs of compute.
+// The sequences for 5 variables are OEIS A056287 (AND and OR)
+// and A178939 (AND, OR and XOR).
+var knownCounts = map[checkpoint.Basis][][]int{
+	0: {
+		1: {1, 1},
+		2: {1, 2, 0, 1},
+		3: {1, 2, 2, 2, 3, 2, 0, 1, 0, 1},
+		4: {1, 2, 2, 7, 7, 20, 23, 37, 27, 33, 16, 30, 3, 8, 2, 4},
+		5: {1, 2, 2, 7, 19, 44, 142, 436, 1209, 3307, 7741, 17257, 31851, 53901, 75248,
+			94572, 98237, 89342, 66951, 41664, 21481, 8680, 2730, 937, 228, 103, 21, 10, 3},
+	},
+	checkpoint.Xor: {
+		1: {1, 1},
+		2: {1, 3},
+		3: {1, 3, 5, 3, 2},
+		4: {1, 3, 5, 20, 34, 75, 68, 16},
+		5: {1, 3, 5, 20, 93, 366, 1730, 8782, 40297, 141422, 273277, 145707, 4423},
+	},
+	checkpoint.Nand: nandCounts,
+	checkpoint.Nor:  nandCounts, // NOR formulas are the duals of NAND formulas
+	checkpoint.Mux: {
+		1: {1, 1},
+		2: {1, 3},
+		3: {1, 4, 7, 2},
+		4: {1, 4, 17, 82, 94, 23, 1},
+	},
+	checkpoint.Maj: {
+		1: {1, 1},
+		2: {1, 2, 0, 1},
+		3: {1, 3, 2, 4, 4},
+		4: {1, 3, 5, 18, 37, 84, 63, 7, 2, 2},
+	},
+}
+
+// knownDepthCounts[basis][n][depth] is the number of NPN classes
+// (or NP classes) of functions of n variables whose minimal formulas
+// in the basis have the given depth.
+var knownDepthCounts = map[checkpoint.Basis][][]int{
+	0: {
+		1: {1, 1},
+		2: {1, 2, 1},
+		3: {1, 2, 4, 5, 2},
+		4: {1, 2, 7, 59, 151, 2},
+	},
+	checkpoint.Xor: {
+		1: {1, 1},
+		2: {1, 3},
+		3: {1, 3, 8, 2},
+		4: {1, 3, 17, 179, 22},
+	},
+	checkpoint.Nand: nandDepthCounts,
+	checkpoint.Nor:  nandDepthCounts,
+}
+
+// nandCounts are the counts of NP classes for the NAND basis.
+var nandCounts = [][]int{
+	1: {1, 1, 0, 1},
+	2: {1, 2, 0, 3},
+	3: {1, 2, 1, 6, 3, 3, 2, 2, 1, 1},
+	4: {1, 2, 1, 8, 10, 23, 30, 51, 51, 71, 35, 49, 32, 17, 10, 7, 3, 1},
+}
+
+// nandDepthCounts are the counts of NP classes by depth for the NAND basis.
+var nandDepthCounts = [][]int{
+	1: {1, 1, 1},
+	2: {1, 2, 3},
+	3: {1, 2, 6, 5, 8},
+	4: {1, 2, 7, 33, 337, 22},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: verify [-partial] file.ckpt...\n")
+	os.Exit(2)
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("verify: ")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+	}
+	ok := true
+	for _, file := range flag.Args() {
+		if !verify(file) {
+			ok = false
+		}
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// A checker accumulates the errors found in a table.
+type checker struct {
+	file string
+	mu   sync.Mutex
+	n    int
+}
+
+func (c *checker) errorf(format string, args ...interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.n++
+	if c.n <= maxErrors {
+		log.Printf("%s: %s", c.file, fmt.Sprintf(format, args...))
+	}
+	if c.n == maxErrors+1 {
+		log.Printf("%s: too many errors", c.file)
+	}
+}
+
+func (c *checker) failed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.n > maxErrors
+}
+
+// verify checks the table in file and reports whether it is valid.
+func verify(file string) bool {
+	c := &checker{file: file}
+	ct, err := checkpoint.Read(file)
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+	if ct.Metric == checkpoint.Circuit {
+		return verifyCircuits(file, ct)
+	}
+	t, err := table.New(ct)
+	if err != nil {
+		log.Printf("%s: %v", file, err)
+		return false
+	}
+	all := t.All()
+	depth := t.Metric == checkpoint.Depth
+
+	// Costs and canonical forms.
+	// In a depth table, the entries for a class are sorted by depth,
+	// and each must be smaller than the one before.
+	// Without costs, the cost of each entry is its size.
+	cost := make(map[table.Func]int)
+	for i, e := range t.Entries {
+		if _, ok := cost[e.F]; ok {
+			if prev := &t.Entries[i-1]; !depth {
+				c.errorf("duplicate record for %s", t.Format(e.F))
+			} else if e.Depth == prev.Depth || e.Size >= prev.Size {
+				c.errorf("record for %s at depth %d has size %d, but depth %d has size %d", t.Format(e.F), e.Depth, e.Size, prev.Depth, prev.Size)
+			}
+		}
+		cost[e.F] = e.Cost
+	}
+	withCosts := t.Costs != (checkpoint.Costs{})
+	lit := t.Canon(t.Literal(0))
+	classSize := make([]int, len(t.Entries))
+	forEach(len(t.Entries), func(i int) {
+		if c.failed() {
+			return
+		}
+		e := &t.Entries[i]
+		if f := t.Canon(e.F); f != e.F {
+			c.errorf("%s is not canonical (want %s)", t.Format(e.F), t.Format(f))
+			return
+		}
+		if i == 0 || t.Entries[i-1].F != e.F {
+			classSize[i] = t.ClassSize(e.F)
+		}
+
+		// Operator and costs of operands.
+		// Op accepts only the operators in the table's basis.
+		op := t.Op(e)
+		ops := []table.Func{e.P, e.Q}
+		switch op {
+		case "Lit":
+			if e.F != lit || (e.Q != 0 && e.Q != all) || e.R != 0 || e.Size != 0 || e.Cost != 0 || e.Depth != 0 {
+				c.errorf("%s: invalid literal record (P=%s Q=%s R=%s size %d)", t.Format(e.F), t.Format(e.P), t.Format(e.Q), t.Format(e.R), e.Size)
+			}
+			return
+		case "?":
+			c.errorf("%s is not computed from P=%s, Q=%s and R=%s in basis %v", t.Format(e.F), t.Format(e.P), t.Format(e.Q), t.Format(e.R), t.Basis)
+			return
+		case "!":
+			ops = ops[:1]
+		case "mux", "maj":
+			ops = append(ops, e.R)
+		}
+		if !depth {
+			// In a depth table, table.New derives the sizes
+			// from the operands, which it requires at lower depths.
+			n := t.OpCost(op)
+			for _, x := range ops {
+				cx, ok := cost[t.Canon(x)]
+				if !ok {
+					c.errorf("%s = %s %v: operand class missing for %s", t.Format(e.F), op, formatAll(t, ops), t.Format(x))
+					return
+				}
+				n += cx
+			}
+			if e.Cost != n {
+				what := "size"
+				if withCosts {
+					what = "cost"
+				}
+				c.errorf("%s = %s %v: %s %d, but operator and operands have %ss adding to %d", t.Format(e.F), op, formatAll(t, ops), what, e.Cost, what, n)
+			}
+		}
+
+		// Expanded formula.
+		tree, err := t.TreeAt(e.F, e.Depth)
+		if err != nil {
+			c.errorf("%s: %v", t.Format(e.F), err)
+			return
+		}
+		if f := eval(t, tree); f != e.F {
+			c.errorf("%s: formula %v computes %s", t.Format(e.F), tree, t.Format(f))
+		}
+		if n := tree.Size(); n != e.Size {
+			c.errorf("%s: formula %v has size %d, want %d", t.Format(e.F), tree, n, e.Size)
+		}
+		if n := t.Cost(tree); withCosts && n != e.Cost {
+			c.errorf("%s: formula %v has cost %d, want %d", t.Format(e.F), tree, n, e.Cost)
+		}
+		if n := tree.Depth(); depth && n != e.Depth {
+			c.errorf("%s: formula %v has depth %d, want %d", t.Format(e.F), tree, n, e.Depth)
+		}
+	})
+	if c.failed() {
+		return false
+	}
+
+	// Completeness.
+	total, nclass := 0, 0
+	for _, n := range classSize {
+		if n > 0 {
+			total += n
+			nclass++
+		}
+	}
+	want := 1 << (1 << t.NumVar)
+	complete := total == want
+	wantClass := numClass[t.NumVar]
+	if !t.Costs.NPN(t.Basis) {
+		wantClass = numNPClass[t.NumVar]
+	}
+	if complete && nclass != wantClass {
+		c.errorf("%d classes cover all %d functions, but there are %d classes", nclass, want, wantClass)
+	}
+	if !complete && !*partial {
+		c.errorf("table is incomplete: %d classes, %d of %d functions", nclass, total, want)
+	}
+
+	// Counts by level, which is the size, or in a depth table, the depth,
+	// or in a table with costs, the cost.
+	// The header counts records; the known counts count classes.
+	what := "size"
+	known := knownCounts[t.Basis]
+	switch {
+	case depth:
+		what = "depth"
+		known = knownDepthCounts[t.Basis]
+	case withCosts:
+		what = "cost"
+		known = nil
+	}
+	counts := make([]int, len(ct.Counts))
+	classCounts := make([]int, len(ct.Counts))
+	for i := range t.Entries {
+		e := &t.Entries[i]
+		level := e.Cost
+		if depth {
+			level = e.Depth
+		}
+		counts[level]++
+		if classSize[i] > 0 {
+			classCounts[level]++
+		}
+	}
+	for level, n := range ct.Counts {
+		if counts[level] != n {
+			c.errorf("header lists %d records at level %d, but there are %d", n, level, counts[level])
+		}
+	}
+	for len(classCounts) > 0 && classCounts[len(classCounts)-1] == 0 {
+		// A depth table can end with levels that only improve sizes.
+		classCounts = classCounts[:len(classCounts)-1]
+	}
+	if t.NumVar < len(known) && known[t.NumVar] != nil {
+		k := known[t.NumVar]
+		if complete && len(classCounts) != len(k) {
+			c.errorf("table has %ss up to %d, want %d", what, len(classCounts)-1, len(k)-1)
+		}
+		for level, n := range classCounts {
+			if level < len(k) && n != k[level] {
+				c.errorf("%d classes of %s %d, want %d", n, what, level, k[level])
+			}
+		}
+	} else if withCosts {
+		log.Printf("%s: no known counts for tables with costs", file)
+	} else {
+		log.Printf("%s: no known counts for %d variables in this basis", file, t.NumVar)
+	}
+
+	if c.n > 0 {
+		return false
+	}
+	status := "complete"
+	if !complete {
+		status = fmt.Sprintf("incomplete, %d of %d functions", total, want)
+	}
+	fmt.Printf("%s: ok: %d classes, %ss 0-%d (%s)\n", file, nclass, what, ct.Level(), status)
+	return true
+}
+
+// formatAll returns the hexadecimal forms of fs.
+func formatAll(t *table.Table, fs []table.Func) []string {
+	var list []string
+	for _, f := range fs {
+		list = append(list, t.Format(f))
+	}
+	return list
+}
+
+// eval returns the function computed by the formula x.
+func eval(t *table.Table, x *table.Tree) table.Func {
+	if x.Op == "Lit" {
+		f := t.Literal(x.Var)
+		if x.Neg {
+			f ^= t.All()
+		}
+		return f
+	}
+	var a []table.Func
+	for _, y := range x.Args {
+		a = append(a, eval(t, y))
+	}
+	switch x.Op {
+	case "&":
+		return a[0] & a[1]
+	case "|":
+		return a[0] | a[1]
+	case "^":
+		return a[0] ^ a[1]
+	case "!":
+		return a[0] ^ t.All()
+	case "!&":
+		return (a[0] & a[1]) ^ t.All()
+	case "!|":
+		return (a[0] | a[1]) ^ t.All()
+	case "mux":
+		return a[0]&a[1] | (a[0]^t.All())&a[2]
+	case "maj":
+		return a[0]&a[1] | a[0]&a[2] | a[1]&a[2]
+	}
+	panic("unknown operator " + x.Op)
+}
+
+// forEach calls f(i) for each i from 0 to n-1, in parallel.
+func forEach(n int, f func(i int)) {
+	var wg sync.WaitGroup
+	p := runtime.NumCPU()
+	for w := 0; w < p; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < n; i += p {
+				f(i)
+			}
+		}(w)
+	}
+	wg.Wait()
+}
@@ -7,6 +7,7 @@ require (
 	golang.org/x/mod v0.10.0
 	google.golang.org/appengine v1.6.6
 	google.golang.org/appengine/v2 v2.0.3
+	rsc.io/markdown v0.0.0-20240306144322-0bf8f97ee8ef
 )
 
 require (
@@ -29,3 +30,9 @@ require (
 	google.golang.org/protobuf v1.24.0 // indirect
 	honnef.co/go/tools v0.0.1-2020.1.4 // indirect
 )
+
+require rsc.io/swtch/boolean-oracle/app v0.0.0-00010101000000-000000000000
+
+// The boolean oracle's web app is a separate module, so that it can be
+// deployed on its own, but compute shares its checkpoint package.
+replace rsc.io/swtch/boolean-oracle/app => ./boolean-oracle/app